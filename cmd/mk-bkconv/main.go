@@ -22,12 +22,14 @@ func main() {
 
 	// allow global flags (such as --allow-fallback) to appear anywhere
 	allowSourcesFallback := slices.Contains(os.Args, "--allow-fallback")
+	args, mappingFile := extractValueFlag(args, "mapping")
+	args, indexFile := extractValueFlag(args, "extension-index")
 
 	// find the subcommand if it's present anywhere among the args
 	var sub string
 	subIndex := -1
 	for i, a := range args {
-		if a == "mihon-to-kotatsu" || a == "kotatsu-to-mihon" {
+		if a == "mihon-to-kotatsu" || a == "kotatsu-to-mihon" || a == "kotatsu-to-kotatsu" {
 			sub = a
 			subIndex = i
 			break
@@ -83,11 +85,39 @@ func main() {
 		}
 		filteredArgs = append(filteredArgs, a)
 	}
+
+	registry := convert.NewDefaultSourceRegistry()
+	if indexFile != "" {
+		exts, err := convert.LoadExtensionIndex(indexFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading extension index: %v\n", err)
+			os.Exit(2)
+		}
+		registry.RegisterExtensions(exts...)
+		fmt.Printf("Loaded %d extensions from %s\n", len(exts), indexFile)
+	}
+	if mappingFile != "" {
+		overrides, err := convert.LoadSourceMappings(mappingFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading source mappings: %v\n", err)
+			os.Exit(2)
+		}
+		conflicts := registry.ApplyMappings(overrides)
+		fmt.Printf("Loaded %d source mappings from %s\n", len(overrides), mappingFile)
+		if len(conflicts) > 0 {
+			fmt.Printf("⚠️  Source mapping conflicts (entries from the file win):\n")
+			for _, c := range conflicts {
+				fmt.Printf("   • %s\n", c)
+			}
+		}
+	}
+
 	switch sub {
 	case "mihon-to-kotatsu":
 		fs := flag.NewFlagSet("mihon-to-kotatsu", flag.ExitOnError)
 		in := fs.String("in", "", "input mihon backup file (.tachibk)")
 		out := fs.String("out", "", "output kotatsu zip file")
+		defaultCategory := fs.String("default-category", convert.DefaultCategoryName, "category for manga without one")
 		fs.Parse(filteredArgs)
 		if *in == "" || *out == "" {
 			usage()
@@ -98,17 +128,22 @@ func main() {
 			fmt.Fprintf(os.Stderr, "error reading mihon backup: %v\n", err)
 			os.Exit(3)
 		}
-		kb := convert.MihonToKotatsu(b)
+		kb, report := convert.MihonToKotatsu(b, convert.Options{
+			DefaultCategory: *defaultCategory,
+			Registry:        registry,
+		})
 		if err := kotatsu.WriteKotatsuZip(*out, kb); err != nil {
 			fmt.Fprintf(os.Stderr, "error writing kotatsu zip: %v\n", err)
 			os.Exit(4)
 		}
+		report.Print(os.Stdout)
 		fmt.Println("Conversion complete.")
 
 	case "kotatsu-to-mihon":
 		fs := flag.NewFlagSet("kotatsu-to-mihon", flag.ExitOnError)
 		in := fs.String("in", "", "input kotatsu zip file")
 		out := fs.String("out", "", "output mihon backup file (.tachibk)")
+		defaultCategory := fs.String("default-category", convert.DefaultCategoryName, "category for favourites whose category is missing")
 		fs.Parse(filteredArgs)
 		if *in == "" || *out == "" {
 			usage()
@@ -119,7 +154,11 @@ func main() {
 			fmt.Fprintf(os.Stderr, "error reading kotatsu zip: %v\n", err)
 			os.Exit(3)
 		}
-		b, err := convert.KotatsuToMihon(kb, allowSourcesFallback)
+		b, report, err := convert.KotatsuToMihon(kb, convert.Options{
+			AllowSourceFallback: allowSourcesFallback,
+			DefaultCategory:     *defaultCategory,
+			Registry:            registry,
+		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error converting kotatsu to mihon: %v\n", err)
 			os.Exit(5)
@@ -128,6 +167,29 @@ func main() {
 			fmt.Fprintf(os.Stderr, "error writing mihon backup: %v\n", err)
 			os.Exit(4)
 		}
+		report.Print(os.Stdout)
+		fmt.Println("Conversion complete.")
+
+	case "kotatsu-to-kotatsu":
+		// Rewrites a Kotatsu backup, e.g. to repair it; sections it does not
+		// change are copied byte for byte
+		fs := flag.NewFlagSet("kotatsu-to-kotatsu", flag.ExitOnError)
+		in := fs.String("in", "", "input kotatsu zip file")
+		out := fs.String("out", "", "output kotatsu zip file")
+		fs.Parse(filteredArgs)
+		if *in == "" || *out == "" {
+			usage()
+			os.Exit(2)
+		}
+		kb, err := kotatsu.LoadKotatsuZip(*in)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading kotatsu zip: %v\n", err)
+			os.Exit(3)
+		}
+		if err := kotatsu.WriteKotatsuZip(*out, kb); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing kotatsu zip: %v\n", err)
+			os.Exit(4)
+		}
 		fmt.Println("Conversion complete.")
 
 	default:
@@ -136,10 +198,35 @@ func main() {
 	}
 }
 
+// extractValueFlag removes a global flag taking a value (--name <value> or
+// --name=<value>, with one or two dashes) from args and returns its value.
+func extractValueFlag(args []string, name string) ([]string, string) {
+	var value string
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--"+name || a == "-"+name:
+			if i+1 < len(args) {
+				value = args[i+1]
+				i++
+			}
+		case strings.HasPrefix(a, "--"+name+"=") || strings.HasPrefix(a, "-"+name+"="):
+			value = a[strings.Index(a, "=")+1:]
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return rest, value
+}
+
 func usage() {
 	fmt.Println("mk-bkconv: convert between Mihon and Kotatsu backups")
 	fmt.Println("USAGE:")
-	fmt.Println("  mk-bkconv <mihon-to-kotatsu|kotatsu-to-mihon> -in <input> -out <output> --allow-fallback")
+	fmt.Println("  mk-bkconv <mihon-to-kotatsu|kotatsu-to-mihon|kotatsu-to-kotatsu> -in <input> -out <output> --allow-fallback --mapping <file> --extension-index <file>")
 	fmt.Println("    --allow-fallback   this flag allows you to fallback to hashing when there was no mapping for a source found")
+	fmt.Println("    -default-category  name of the category that receives manga without a valid category (default \"" + convert.DefaultCategoryName + "\")")
+	fmt.Println("    --mapping <file>   JSON or CSV file of source mappings that override or extend the built-in ones")
+	fmt.Println("    --extension-index <file>  extension repo index.min.json to take Mihon source IDs from (default: embedded snapshot)")
 
 }
@@ -0,0 +1,61 @@
+package convert
+
+import (
+	"fmt"
+
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+)
+
+// generatePageID creates a deterministic page ID for a bookmark. Kotatsu keys
+// bookmarks by (manga_id, page_id) and the real page URLs are only known once the
+// chapter has been loaded, so this only has to be unique per chapter page.
+func generatePageID(source, chapterURL string, page int64) int64 {
+	return kotatsu.GenerateUid(source, fmt.Sprintf("%s#%d", chapterURL, page))
+}
+
+// mihonBookmarksToKotatsu turns every bookmarked chapter of a Mihon manga into a
+// Kotatsu page bookmark pointing at the last read page of that chapter.
+func mihonBookmarksToKotatsu(m *pb.BackupManga, km kotatsu.KotatsuManga) []kotatsu.KotatsuBookmark {
+	lastRead := make(map[string]int64, len(m.GetHistory()))
+	for _, h := range m.GetHistory() {
+		lastRead[h.GetUrl()] = h.GetLastRead()
+	}
+
+	var out []kotatsu.KotatsuBookmark
+	for _, c := range m.GetChapters() {
+		if !c.GetBookmark() {
+			continue
+		}
+		createdAt := lastRead[c.GetUrl()]
+		if createdAt == 0 {
+			createdAt = m.GetDateAdded()
+		}
+		out = append(out, kotatsu.KotatsuBookmark{
+			MangaId:   km.Id,
+			PageId:    generatePageID(km.Source, c.GetUrl(), c.GetLastPageRead()),
+			ChapterId: generateChapterID(km.Source, c.GetUrl()),
+			Page:      int(c.GetLastPageRead()),
+			CreatedAt: createdAt,
+		})
+	}
+	return out
+}
+
+// applyKotatsuBookmarks flags every chapter of a converted Mihon manga that holds
+// at least one Kotatsu page bookmark. kc must be the Kotatsu chapter list
+// m.Chapters was built from, in the same order. It returns the number of
+// bookmarked chapters missing from the chapter list, whose bookmarks are lost.
+func applyKotatsuBookmarks(m *pb.BackupManga, kc []kotatsu.KotatsuChapter, bookmarked map[int64]bool) (missing int) {
+	if len(kc) != len(m.Chapters) {
+		return len(bookmarked)
+	}
+	found := 0
+	for i, c := range kc {
+		if bookmarked[c.Id] {
+			m.Chapters[i].Bookmark = boolPtr(true)
+			found++
+		}
+	}
+	return len(bookmarked) - found
+}
@@ -0,0 +1,80 @@
+package convert
+
+import (
+	"sort"
+
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+)
+
+// Kotatsu shows one branch of a manga at a time (a translation or scanlation
+// group), the one the current history chapter belongs to. Mihon shows every
+// chapter except those of excluded scanlators, so a branch choice becomes the
+// exclusion of every scanlator outside that branch and back.
+
+// kotatsuChapterScanlator returns the Mihon scanlator of a Kotatsu chapter.
+// Parsers often leave the scanlator empty and name the group in the branch,
+// which is then the only thing Mihon can filter on.
+func kotatsuChapterScanlator(c kotatsu.KotatsuChapter) string {
+	if c.Scanlator != "" {
+		return c.Scanlator
+	}
+	return c.Branch
+}
+
+// applyKotatsuBranch excludes the scanlators of every branch but the one of the
+// current history chapter. kc must be the Kotatsu chapter list m.Chapters was
+// built from, in the same order. Scanlators that also appear in the current
+// branch stay visible.
+func applyKotatsuBranch(m *pb.BackupManga, kc []kotatsu.KotatsuChapter, h kotatsu.KotatsuHistory) {
+	if len(kc) != len(m.Chapters) {
+		return
+	}
+	current, found := "", false
+	for _, c := range kc {
+		if c.Id == h.ChapterId {
+			current, found = c.Branch, true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	keep := make(map[string]bool)
+	for i, c := range kc {
+		if c.Branch == current {
+			keep[m.Chapters[i].GetScanlator()] = true
+		}
+	}
+	excluded := make(map[string]bool)
+	for i, c := range kc {
+		if s := m.Chapters[i].GetScanlator(); c.Branch != current && s != "" && !keep[s] {
+			excluded[s] = true
+		}
+	}
+	for s := range excluded {
+		m.ExcludedScanlators = append(m.ExcludedScanlators, s)
+	}
+	sort.Strings(m.ExcludedScanlators)
+}
+
+// mihonChapterBranch returns the Kotatsu branch of a Mihon chapter. Chapters of
+// excluded scanlators get a branch named after the scanlator, everything Mihon
+// shows stays in the default (unnamed) branch, so the visible chapters end up in
+// the single branch Kotatsu displays.
+func mihonChapterBranch(c *pb.BackupChapter, excluded map[string]bool) string {
+	if excluded[c.GetScanlator()] {
+		return c.GetScanlator()
+	}
+	return ""
+}
+
+// excludedScanlators returns the excluded scanlators of a Mihon manga as a set.
+func excludedScanlators(m *pb.BackupManga) map[string]bool {
+	excluded := make(map[string]bool, len(m.GetExcludedScanlators()))
+	for _, s := range m.GetExcludedScanlators() {
+		excluded[s] = true
+	}
+	return excluded
+}
@@ -0,0 +1,183 @@
+package convert
+
+import (
+	"sort"
+	"time"
+
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+)
+
+// Mihon's LibrarySort flags, stored in BackupCategory.flags
+const (
+	mihonSortTypeMask      int64 = 0b00111100
+	mihonSortAlphabetical  int64 = 0b00000000
+	mihonSortLastRead      int64 = 0b00000100
+	mihonSortLastUpdate    int64 = 0b00001000
+	mihonSortUnreadCount   int64 = 0b00001100
+	mihonSortDateAdded     int64 = 0b00011100
+	mihonSortDirectionMask int64 = 0b01000000
+	mihonSortAscending     int64 = 0b01000000
+	mihonSortDescending    int64 = 0b00000000
+	mihonSortFlagsMask           = mihonSortTypeMask | mihonSortDirectionMask
+)
+
+// kotatsuDefaultSortOrder is what Kotatsu sorts a category by when it has no
+// order option.
+const kotatsuDefaultSortOrder = "NEWEST"
+
+// kotatsuSortToMihon maps Kotatsu's favourites ListSortOrder names to Mihon
+// library sort flags. Orders missing here (PROGRESS, NEW_CHAPTERS, RATING, ...)
+// have no Mihon equivalent.
+var kotatsuSortToMihon = map[string]int64{
+	"ALPHABETIC":         mihonSortAlphabetical | mihonSortAscending,
+	"ALPHABETIC_REVERSE": mihonSortAlphabetical | mihonSortDescending,
+	"NEWEST":             mihonSortDateAdded | mihonSortDescending,
+	"OLDEST":             mihonSortDateAdded | mihonSortAscending,
+	"LAST_READ":          mihonSortLastRead | mihonSortDescending,
+	"LONG_AGO_READ":      mihonSortLastRead | mihonSortAscending,
+	"UNREAD":             mihonSortUnreadCount | mihonSortDescending,
+	"UPDATED":            mihonSortLastUpdate | mihonSortDescending,
+}
+
+// mihonSortToKotatsu returns the Kotatsu sort order for Mihon category flags,
+// or an empty order (Kotatsu's default) if there is no equivalent.
+func mihonSortToKotatsu(flags int64) (order string, found bool) {
+	for k, v := range kotatsuSortToMihon {
+		if v == flags&mihonSortFlagsMask {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// mihonCategoriesToKotatsu converts Mihon categories. Mihon's order becomes
+// Kotatsu's sort_key; since Mihon keeps no creation time, created_at is the
+// conversion time, spaced one millisecond apart to follow the order. Categories
+// without an id (older backups) get one that does not collide with the others.
+// The returned map resolves the order values used by BackupManga.categories to
+// Kotatsu category ids.
+func mihonCategoriesToKotatsu(cats []*pb.BackupCategory, report *Report) ([]kotatsu.KotatsuCategory, map[int64]int64) {
+	sorted := append([]*pb.BackupCategory(nil), cats...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].GetOrder() < sorted[j].GetOrder() })
+
+	used := make(map[int64]bool)
+	for _, c := range sorted {
+		if c.GetId() > 0 {
+			used[c.GetId()] = true
+		}
+	}
+	nextID := int64(1)
+
+	now := time.Now().UnixMilli()
+	byOrder := make(map[int64]int64, len(sorted))
+	out := make([]kotatsu.KotatsuCategory, 0, len(sorted))
+	for i, c := range sorted {
+		id := c.GetId()
+		if id <= 0 {
+			for used[nextID] {
+				nextID++
+			}
+			id = nextID
+			used[id] = true
+		}
+		byOrder[c.GetOrder()] = id
+
+		order, found := mihonSortToKotatsu(c.GetFlags())
+		if !found {
+			report.Warnf("category %q: library sort has no Kotatsu equivalent, using Kotatsu's default", c.GetName())
+		}
+		out = append(out, kotatsu.KotatsuCategory{
+			CategoryId: id,
+			CreatedAt:  now + int64(i),
+			SortKey:    int(c.GetOrder()),
+			Title:      c.GetName(),
+			Order:      order,
+			Track:      boolPtr(true),
+			ShowInLib:  boolPtr(true),
+		})
+	}
+	return out, byOrder
+}
+
+// kotatsuCategoriesToMihon converts Kotatsu categories. They are ordered by
+// sort_key and Mihon's order is the resulting position, which keeps order values
+// unique even if sort keys collide. The returned map resolves Kotatsu category
+// ids to the order values BackupManga.categories refers to.
+func kotatsuCategoriesToMihon(cats []kotatsu.KotatsuCategory, report *Report) ([]*pb.BackupCategory, map[int64]int64) {
+	sorted := append([]kotatsu.KotatsuCategory(nil), cats...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].SortKey < sorted[j].SortKey })
+
+	orderByID := make(map[int64]int64, len(sorted))
+	out := make([]*pb.BackupCategory, 0, len(sorted))
+	for i, c := range sorted {
+		order := c.Order
+		if order == "" {
+			order = kotatsuDefaultSortOrder
+		}
+		flags, ok := kotatsuSortToMihon[order]
+		if !ok {
+			report.Warnf("category %q: sort order %s has no Mihon equivalent", c.Title, order)
+		}
+		if c.Track != nil && !*c.Track {
+			report.Warnf("category %q: Mihon has no per-category switch to disable update tracking", c.Title)
+		}
+		if c.ShowInLib != nil && !*c.ShowInLib {
+			report.Warnf("category %q: Mihon cannot hide a category from the library", c.Title)
+		}
+
+		bc := &pb.BackupCategory{
+			Name:  stringPtr(c.Title),
+			Order: int64Ptr(int64(i)),
+			Id:    int64Ptr(c.CategoryId),
+			Flags: int64Ptr(flags),
+		}
+		orderByID[c.CategoryId] = bc.GetOrder()
+		out = append(out, bc)
+	}
+	return out, orderByID
+}
+
+// kotatsuDefaultCategory returns the id of the Kotatsu category called name,
+// appending it after the existing categories if there is none yet.
+func kotatsuDefaultCategory(kb *kotatsu.KotatsuBackup, name string) int64 {
+	var maxID int64
+	sortKey, createdAt := 0, time.Now().UnixMilli()
+	for _, c := range kb.Categories {
+		if c.Title == name {
+			return c.CategoryId
+		}
+		maxID = max(maxID, c.CategoryId)
+		sortKey = max(sortKey, c.SortKey+1)
+		createdAt = max(createdAt, c.CreatedAt+1)
+	}
+	kb.Categories = append(kb.Categories, kotatsu.KotatsuCategory{
+		CategoryId: maxID + 1,
+		CreatedAt:  createdAt,
+		SortKey:    sortKey,
+		Title:      name,
+		Track:      boolPtr(true),
+		ShowInLib:  boolPtr(true),
+	})
+	return maxID + 1
+}
+
+// mihonDefaultCategory returns the order of the Mihon category called name,
+// appending it after the existing categories if there is none yet.
+func mihonDefaultCategory(b *pb.Backup, name string) int64 {
+	var maxID, order int64
+	for _, c := range b.BackupCategories {
+		if c.GetName() == name {
+			return c.GetOrder()
+		}
+		maxID = max(maxID, c.GetId())
+		order = max(order, c.GetOrder()+1)
+	}
+	b.BackupCategories = append(b.BackupCategories, &pb.BackupCategory{
+		Name:  stringPtr(name),
+		Order: int64Ptr(order),
+		Id:    int64Ptr(maxID + 1),
+		Flags: int64Ptr(kotatsuSortToMihon[kotatsuDefaultSortOrder]),
+	})
+	return order
+}
@@ -0,0 +1,92 @@
+package convert
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Chapter number recognition, modelled on Mihon's ChapterRecognition. Go's
+// regexp has no lookarounds, so those patterns are rewritten with groups.
+const chapterNumberPattern = `([0-9]+)(\.[0-9]+)?(\.?[a-z]+)?`
+
+var (
+	// "Mokushiroku Alice Vol.1 Ch. 4: Misrepresentation" -> 4
+	chapterBasicRe = regexp.MustCompile(`ch\. *` + chapterNumberPattern)
+	// "Bleach 567: Down With Snowwhite" -> 567
+	chapterNumberRe = regexp.MustCompile(chapterNumberPattern)
+	// "Prison School 12 v.1 vol004 version1243 volume64" -> "Prison School 12"
+	chapterUnwantedRe = regexp.MustCompile(`\b(?:v|ver|vol|version|volume|season|s)[^a-z]?[0-9]+`)
+	// "One Piece 12 special" -> "One Piece 12special"
+	chapterUnwantedSpaceRe = regexp.MustCompile(`\s(extra|special|omake)`)
+	// "Vol.2 Ch.10", "Volume 3 - Chapter 1" -> 2, 3
+	chapterVolumeRe = regexp.MustCompile(`\b(?:vol|volume)\.? *([0-9]+)`)
+)
+
+// recognizeChapterNumber extracts the volume and chapter number from a chapter
+// name the way Mihon does: the manga title is removed, decimals ("10.5"),
+// letter suffixes ("10a" -> 10.1) and extras ("10 extra" -> 10.99) are
+// understood, and volume tags are skipped when looking for the chapter. Only
+// single letter suffixes count ("10ab" is 10). A name whose only numbers are
+// volume, season or version tags ("Season 2 Vol.3") has no chapter number.
+// volume is 0 when the name has none; ok is false when no number was found.
+func recognizeChapterNumber(mangaTitle, chapterName string) (volume int, number float32, ok bool) {
+	name := strings.ToLower(chapterName)
+	if t := strings.ToLower(strings.TrimSpace(mangaTitle)); t != "" {
+		name = strings.ReplaceAll(name, t, "")
+	}
+	name = strings.TrimSpace(name)
+	name = strings.NewReplacer(",", ".", "-", ".").Replace(name)
+	name = chapterUnwantedSpaceRe.ReplaceAllString(name, "$1")
+
+	if m := chapterVolumeRe.FindStringSubmatch(name); m != nil {
+		volume, _ = strconv.Atoi(m[1])
+	}
+
+	matches := chapterNumberRe.FindAllStringSubmatch(name, -1)
+	switch {
+	case len(matches) == 0:
+		return volume, 0, false
+	case len(matches) > 1:
+		cleaned := chapterUnwantedRe.ReplaceAllString(name, "")
+		if m := chapterBasicRe.FindStringSubmatch(cleaned); m != nil {
+			return volume, chapterNumberFromMatch(m), true
+		}
+		if m := chapterNumberRe.FindStringSubmatch(cleaned); m != nil {
+			return volume, chapterNumberFromMatch(m), true
+		}
+		return volume, 0, false
+	}
+	return volume, chapterNumberFromMatch(matches[0]), true
+}
+
+// chapterNumberFromMatch combines the integer, decimal and suffix groups of a
+// chapterNumberPattern match.
+func chapterNumberFromMatch(m []string) float32 {
+	initial, _ := strconv.ParseFloat(m[1], 64)
+	return float32(initial + chapterNumberAddition(m[2], m[3]))
+}
+
+func chapterNumberAddition(decimal, alpha string) float64 {
+	if decimal != "" {
+		d, _ := strconv.ParseFloat(decimal, 64)
+		return d
+	}
+	switch {
+	case alpha == "":
+		return 0
+	case strings.Contains(alpha, "extra"):
+		return 0.99
+	case strings.Contains(alpha, "omake"):
+		return 0.98
+	case strings.Contains(alpha, "special"):
+		return 0.97
+	}
+	if a := strings.TrimLeft(alpha, "."); len(a) == 1 {
+		// "10a" -> 10.1, "10b" -> 10.2, ...
+		if n := int(a[0]-'a') + 1; n < 10 {
+			return float64(n) / 10
+		}
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package convert
+
+import "testing"
+
+// Cases from Mihon's ChapterRecognitionTest, plus comma decimals and volume
+// extraction.
+func TestRecognizeChapterNumber(t *testing.T) {
+	tests := []struct {
+		manga, chapter string
+		volume         int
+		number         float32 // -1 when no number is recognized
+	}{
+		// Basic Ch. prefix
+		{"Mokushiroku Alice", "Mokushiroku Alice Vol.1 Ch. 4: Misrepresentation", 1, 4},
+		{"Mokushiroku Alice", "Mokushiroku Alice Vol.1 Ch.4: Misrepresentation", 1, 4},
+		{"", "Ch.4: Misrepresentation", 0, 4},
+		// Decimals
+		{"Mokushiroku Alice", "Mokushiroku Alice Vol.1 Ch.4.1: Misrepresentation", 1, 4.1},
+		{"Mokushiroku Alice", "Mokushiroku Alice Vol.1 Ch.4.4: Misrepresentation", 1, 4.4},
+		{"Bleach", "Bleach 567.1: Down With Snowwhite", 0, 567.1},
+		{"Bleach", "Bleach 567.5: Down With Snowwhite", 0, 567.5},
+		{"", "Chapter 1,5", 0, 1.5},
+		// Alpha suffixes
+		{"Mokushiroku Alice", "Mokushiroku Alice Vol.1 Ch.4.a: Misrepresentation", 1, 4.1},
+		{"Mokushiroku Alice", "Mokushiroku Alice Vol.1 Ch.4.b: Misrepresentation", 1, 4.2},
+		{"Bleach", "Bleach 567a: Down With Snowwhite", 0, 567.1},
+		{"Bleach", "Bleach 567b: Down With Snowwhite", 0, 567.2},
+		{"Bleach", "Bleach 567ab: Down With Snowwhite", 0, 567},
+		// Extra, omake and special
+		{"Mokushiroku Alice", "Mokushiroku Alice Vol.1 Ch.4.extra: Misrepresentation", 1, 4.99},
+		{"Mokushiroku Alice", "Mokushiroku Alice Vol.1 Ch.4.omake: Misrepresentation", 1, 4.98},
+		{"Mokushiroku Alice", "Mokushiroku Alice Vol.1 Ch.4.special: Misrepresentation", 1, 4.97},
+		{"", "Ch.567 extra", 0, 567.99},
+		{"One Piece", "One Piece 12 special", 0, 12.97},
+		// Manga title removed before looking for the number
+		{"Bleach", "Bleach 567: Down With Snowwhite", 0, 567},
+		{"Bleach", "Bleach Chapter 567: Down With Snowwhite", 0, 567},
+		{"Tokyo ESP", "Tokyo ESP 027: Part 002: Chapter 001", 0, 27},
+		{"Kiss x Sis", "Kiss x Sis - Ch.15 - The Angst of a 15 Year Old Boy", 0, 15},
+		{"Onepunch-Man", "Onepunch-Man Punch Ver002 028", 0, 28},
+		// Volume and version tags skipped
+		{"Prison School", "Prison School 12 v.1 vol004 version1243 volume64", 4, 12},
+		{"Solanin", "Solanin 028 Vol. 2", 2, 28},
+		{"", "Vol.2 Ch.10", 2, 10},
+		// Unknown
+		{"", "Chapter 0", 0, 0},
+		{"", "Prologue", 0, -1},
+		{"", "Season 2 Vol.3", 3, -1},
+	}
+	for _, tt := range tests {
+		volume, number, ok := recognizeChapterNumber(tt.manga, tt.chapter)
+		if !ok {
+			number = -1
+		}
+		if number != tt.number || volume != tt.volume {
+			t.Errorf("recognizeChapterNumber(%q, %q) = vol %d, ch %g; want vol %d, ch %g",
+				tt.manga, tt.chapter, volume, number, tt.volume, tt.number)
+		}
+	}
+}
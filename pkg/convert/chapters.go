@@ -0,0 +1,175 @@
+package convert
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+)
+
+// stubURLPrefix marks chapters rebuilt from Kotatsu history. The real chapter
+// URLs are not in the backup, so stubs cannot be merged with the real chapters
+// by URL: Mihon drops them on the first library refresh and carries their read
+// state over to the real chapters with the same number instead.
+const stubURLPrefix = "kotatsu-history:"
+
+// chapterOrderKey holds what the order of a chapter is derived from.
+type chapterOrderKey struct {
+	number     float32 // <= 0 if unknown
+	uploadDate int64   // 0 if unknown
+	position   int     // position in the original list, higher is newer
+}
+
+// newerChapter reports whether chapter a comes after chapter b. Chapter numbers
+// decide when both are known, then upload dates, then the original list position.
+func newerChapter(a, b chapterOrderKey) bool {
+	if a.number > 0 && b.number > 0 && a.number != b.number {
+		return a.number > b.number
+	}
+	if a.uploadDate > 0 && b.uploadDate > 0 && a.uploadDate != b.uploadDate {
+		return a.uploadDate > b.uploadDate
+	}
+	return a.position > b.position
+}
+
+// oldestFirst returns the indexes of keys ordered from the oldest chapter to the
+// newest one.
+func oldestFirst(keys []chapterOrderKey) []int {
+	idx := make([]int, len(keys))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return newerChapter(keys[idx[j]], keys[idx[i]]) })
+	return idx
+}
+
+// mihonChapterOrderKeys returns the order keys of Mihon chapters, whose
+// sourceOrder counts from the newest chapter (0).
+func mihonChapterOrderKeys(chapters []*pb.BackupChapter) []chapterOrderKey {
+	keys := make([]chapterOrderKey, len(chapters))
+	for i, c := range chapters {
+		keys[i] = chapterOrderKey{number: c.GetChapterNumber(), uploadDate: c.GetDateUpload(), position: -int(c.GetSourceOrder())}
+	}
+	return keys
+}
+
+// kotatsuChapterNumber returns the Mihon chapter number of a Kotatsu chapter.
+// Kotatsu stores 0 (or -1 in stubs) when a parser could not tell the number, in
+// which case it is recognized from the chapter name; Mihon's unknown is -1.
+func kotatsuChapterNumber(mangaTitle string, c kotatsu.KotatsuChapter) float32 {
+	if c.Number > 0 {
+		return c.Number
+	}
+	if _, n, ok := recognizeChapterNumber(mangaTitle, c.Name); ok {
+		return n
+	}
+	return -1
+}
+
+// kotatsuChaptersToMihon converts a Kotatsu chapter list, keeping its order.
+// Kotatsu lists chapters oldest first while Mihon's sourceOrder counts from the
+// newest chapter (0), so sourceOrder is derived from the chapter ordering. As the
+// chapters were never fetched by Mihon, dateFetch starts at fetchedAt (when the
+// manga was added) and grows by a millisecond per chapter, the way Mihon spaces
+// the fetch times of chapters it inserts together.
+func kotatsuChaptersToMihon(kc []kotatsu.KotatsuChapter, mangaTitle string, fetchedAt int64) []*pb.BackupChapter {
+	numbers := make([]float32, len(kc))
+	keys := make([]chapterOrderKey, len(kc))
+	for i, c := range kc {
+		numbers[i] = kotatsuChapterNumber(mangaTitle, c)
+		keys[i] = chapterOrderKey{number: numbers[i], uploadDate: c.UploadDate, position: i}
+	}
+	rank := make([]int, len(kc))
+	for r, i := range oldestFirst(keys) {
+		rank[i] = r
+	}
+
+	var chapters []*pb.BackupChapter
+	for i, c := range kc {
+		chapters = append(chapters, &pb.BackupChapter{
+			Url:            stringPtr(c.Url),
+			Name:           stringPtr(c.Name),
+			Scanlator:      stringPtr(kotatsuChapterScanlator(c)),
+			Read:           boolPtr(false),
+			Bookmark:       boolPtr(false),
+			LastPageRead:   int64Ptr(0),
+			ChapterNumber:  float32Ptr(numbers[i]),
+			DateFetch:      int64Ptr(fetchedAt + int64(rank[i])),
+			DateUpload:     int64Ptr(c.UploadDate),
+			SourceOrder:    int64Ptr(int64(len(kc) - 1 - rank[i])),
+			LastModifiedAt: int64Ptr(0),
+			Version:        int64Ptr(1),
+		})
+	}
+	return chapters
+}
+
+// historyChapterStubs rebuilds a minimal chapter list from a history record of
+// a manga whose chapter list is not in the backup. Kotatsu computes percent as
+// (chapter index + page fraction) / chapter count, so when both are known the
+// current chapter is number index+1 and a stub is made for every chapter before
+// it. Otherwise only the current chapter is stubbed, with an unknown number that
+// no real chapter matches, and numbered is false.
+func historyChapterStubs(h kotatsu.KotatsuHistory) (stubs []kotatsu.KotatsuChapter, numbered bool) {
+	current := -1
+	if h.Chapters > 0 && h.Percent >= 0 {
+		current = int(math.Floor(float64(h.Percent) * float64(h.Chapters)))
+		current = min(current, h.Chapters-1)
+	}
+
+	for i := 0; i < current; i++ {
+		number := float32(i + 1)
+		url := fmt.Sprintf("%s%d/%g", stubURLPrefix, h.MangaId, number)
+		stubs = append(stubs, kotatsu.KotatsuChapter{
+			Id:     kotatsu.LongHashCode(url),
+			Name:   fmt.Sprintf("Chapter %g", number),
+			Number: number,
+			Url:    url,
+		})
+	}
+
+	last := kotatsu.KotatsuChapter{
+		Id:     h.ChapterId,
+		Name:   "Last read chapter",
+		Number: -1,
+		Url:    fmt.Sprintf("%s%d/%d", stubURLPrefix, h.MangaId, h.ChapterId),
+	}
+	if current >= 0 {
+		last.Number = float32(current + 1)
+		last.Name = fmt.Sprintf("Chapter %g", last.Number)
+	}
+	return append(stubs, last), current >= 0
+}
+
+// mihonChaptersToKotatsu builds the Kotatsu chapter list of a manga, oldest
+// chapter first, with the chapter ids Kotatsu's parser for source would assign.
+// Unknown chapter numbers (-1) are recognized from the chapter name, falling back
+// to Kotatsu's unknown (0); the volume always comes from the name.
+// Chapters of excluded scanlators are moved out of the default branch.
+func mihonChaptersToKotatsu(m *pb.BackupManga, source string) []kotatsu.KotatsuChapter {
+	chapters := m.GetChapters()
+	excluded := excludedScanlators(m)
+	var out []kotatsu.KotatsuChapter
+	for _, i := range oldestFirst(mihonChapterOrderKeys(chapters)) {
+		c := chapters[i]
+		volume, number, ok := recognizeChapterNumber(m.GetTitle(), c.GetName())
+		if c.GetChapterNumber() >= 0 {
+			number = c.GetChapterNumber()
+		} else if !ok {
+			number = 0
+		}
+		out = append(out, kotatsu.KotatsuChapter{
+			Id:         generateChapterID(source, c.GetUrl()),
+			Name:       c.GetName(),
+			Number:     number,
+			Url:        c.GetUrl(),
+			Scanlator:  c.GetScanlator(),
+			UploadDate: c.GetDateUpload(),
+			Branch:     mihonChapterBranch(c, excluded),
+			Volume:     volume,
+		})
+	}
+	return out
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/galpt/mk-bkconv/pkg/kotatsu"
 	pb "github.com/galpt/mk-bkconv/proto/mihon"
@@ -28,14 +30,14 @@ func updateStrategyPtr(u pb.UpdateStrategy) *pb.UpdateStrategy { return &u }
 // generateSourceID creates a deterministic numeric source ID from a Kotatsu source name
 // First attempts to use known source mappings (for sources that exist in both ecosystems)
 // Falls back to FNV hash for unknown sources
-func generateSourceID(sourceName string, allowFallback bool) (int64, error) {
+func generateSourceID(registry *SourceRegistry, sourceName string, allowFallback bool) (int64, error) {
 	if sourceName == "" {
 		// Use MangaDex as fallback
 		return GenerateMihonSourceID("MangaDex", "all", 1), nil
 	}
 
 	// Try known mapping first
-	if id, _, found := LookupKnownSource(sourceName); found {
+	if id, _, found := registry.LookupSourceID(sourceName); found {
 		return id, nil
 	}
 
@@ -48,93 +50,199 @@ func generateSourceID(sourceName string, allowFallback bool) (int64, error) {
 	return int64(h.Sum64()), nil
 }
 
-// MihonToKotatsu converts from protobuf-based Mihon backup to Kotatsu backup
-func MihonToKotatsu(b *pb.Backup) *kotatsu.KotatsuBackup {
+// generateChapterID derives the id Kotatsu gives a chapter of the given source,
+// so that history and bookmark entries match the chapters Kotatsu fetches later.
+func generateChapterID(source, chapterURL string) int64 {
+	return kotatsu.GenerateUid(source, chapterURL)
+}
+
+// MihonToKotatsu converts from protobuf-based Mihon backup to Kotatsu backup.
+// The returned report lists the manga whose source could not be mapped and any
+// field that had no exact counterpart in Kotatsu.
+func MihonToKotatsu(b *pb.Backup, opts Options) (*kotatsu.KotatsuBackup, *Report) {
+	report := &Report{}
+	statuses := newStatusTally()
+	tracking := newTrackingTally()
+	registry := opts.registry()
+	sourceNames := make(map[int64]string, len(b.BackupSources))
+	for _, s := range b.BackupSources {
+		sourceNames[s.GetSourceId()] = s.GetName()
+	}
+
+	// Report manga without a Kotatsu source before the filter below drops them
+	for _, m := range b.BackupManga {
+		if _, found := registry.ReverseLookup(m.GetSource(), sourceNames[m.GetSource()]); !found {
+			name := sourceNames[m.GetSource()]
+			if name == "" {
+				name = "unnamed source"
+			}
+			report.UnknownSources = append(report.UnknownSources,
+				fmt.Sprintf("%q (%s, ID %d)", m.GetTitle(), name, m.GetSource()))
+		}
+	}
+
 	// Ensure the incoming Mihon backup only contains sources that have a corresponding
 	// Kotatsu source implementation (best-effort). This drops entries that would
 	// otherwise point to missing Kotatsu sources.
-	FilterMihonForKotatsu(b)
+	registry.FilterMihonForKotatsu(b)
 
 	kb := &kotatsu.KotatsuBackup{}
 
+	// Mihon's BackupManga.categories holds category order values, not ids
+	categories, categoryByOrder := mihonCategoriesToKotatsu(b.BackupCategories, report)
+	kb.Categories = categories
+
+	uncategorized := 0
 	for i, m := range b.BackupManga {
+		source, _ := registry.ReverseLookup(m.GetSource(), sourceNames[m.GetSource()])
+		description, altTitle := splitAltTitle(m.GetDescription())
+		contentRating, nsfw := contentRatingFromGenre(m.GetGenre())
+		// Same id Kotatsu would give the manga, so restoring into an existing
+		// library merges with it instead of colliding with unrelated entries
+		mangaID := kotatsu.GenerateUid(source, m.GetUrl())
+		km := kotatsu.KotatsuManga{
+			Id:            mangaID,
+			Title:         m.GetTitle(),
+			AltTitle:      altTitle,
+			Url:           m.GetUrl(),
+			PublicUrl:     m.GetUrl(),
+			Rating:        kotatsuRatingUnknown, // Mihon doesn't keep source scores
+			Nsfw:          nsfw,
+			ContentRating: contentRating,
+			CoverUrl:      m.GetThumbnailUrl(),
+			LargeCover:    m.GetThumbnailUrl(),
+			Author:        kotatsuAuthor(m.GetAuthor(), m.GetArtist()),
+			State:         kotatsuState(m.GetStatus(), statuses),
+			Source:        source,
+			Tags:          genreToKotatsuTags(m.GetGenre(), source),
+			Description:   description,
+		}
 		fav := kotatsu.KotatsuFavouriteEntry{
-			MangaId:    int64(i + 1),
-			CategoryId: 0, // Will be updated if manga has categories
+			MangaId:    mangaID,
+			CategoryId: 0,
 			SortKey:    i,
 			Pinned:     false,
 			CreatedAt:  m.GetDateAdded(),
-			Manga: kotatsu.KotatsuManga{
-				Id:         int64(i + 1),
-				Title:      m.GetTitle(),
-				Url:        m.GetUrl(),
-				PublicUrl:  m.GetUrl(),
-				CoverUrl:   m.GetThumbnailUrl(),
-				LargeCover: m.GetThumbnailUrl(),
-				Author:     m.GetAuthor(),
-				Source:     "",
-				Tags:       []interface{}{},
-			},
+			Manga:      km,
+		}
+
+		// Kotatsu stores one favourite per category membership
+		var categoryIDs []int64
+		for _, order := range m.GetCategories() {
+			if id, ok := categoryByOrder[order]; ok && !slices.Contains(categoryIDs, id) {
+				categoryIDs = append(categoryIDs, id)
+			}
+		}
+		// Kotatsu drops favourites that point to a missing category
+		if len(categoryIDs) == 0 {
+			categoryIDs = append(categoryIDs, kotatsuDefaultCategory(kb, opts.defaultCategory()))
+			uncategorized++
+		}
+		for _, id := range categoryIDs {
+			fav.CategoryId = id
+			kb.Favourites = append(kb.Favourites, fav)
+		}
+
+		if len(m.GetChapters()) > 0 {
+			kb.ChapterIndex = append(kb.ChapterIndex, kotatsu.KotatsuIndexEntry{
+				MangaId:  mangaID,
+				Chapters: mihonChaptersToKotatsu(m, source),
+			})
 		}
 
-		// Assign first category if exists
-		if len(m.GetCategories()) > 0 {
-			fav.CategoryId = m.GetCategories()[0]
+		// Carry over the reading position, if the manga has been read at all
+		if h, ok := mihonHistoryToKotatsu(m, km); ok {
+			kb.History = append(kb.History, h)
 		}
+		kb.Bookmarks = append(kb.Bookmarks, mihonBookmarksToKotatsu(m, km)...)
+		kb.Scrobbling = append(kb.Scrobbling, mihonTrackingToKotatsu(m, mangaID, tracking)...)
+	}
 
-		kb.Favourites = append(kb.Favourites, fav)
+	encodeKotatsuSettings(kb, mihonSettingsToKotatsu(b.BackupPreferences, report), report)
+	kb.Sources = mihonSourcesToKotatsu(b, registry, report)
+	if settings := mihonSourceSettingsToKotatsu(b, registry, report); len(settings) > 0 {
+		kb.SourceSettings = settings
+		report.Warnf("settings of %d sources were kept in the %s entry for conversions back to Mihon; Kotatsu does not restore per-source settings, so set them again in Kotatsu", len(settings), kotatsu.SourceSettingsEntry)
 	}
 
-	// Convert categories
-	for _, c := range b.BackupCategories {
-		kb.Categories = append(kb.Categories, kotatsu.KotatsuCategory{
-			CategoryId: c.GetId(),
-			CreatedAt:  c.GetOrder(),
-			SortKey:    0,
-			Title:      c.GetName(),
-		})
+	if uncategorized > 0 {
+		report.Warnf("%d manga had no category and were put into %q", uncategorized, opts.defaultCategory())
 	}
+	statuses.report(report)
+	tracking.report(report)
 
-	return kb
+	return kb, report
 }
 
-// KotatsuToMihon converts from Kotatsu backup to protobuf-based Mihon backup
-func KotatsuToMihon(kb *kotatsu.KotatsuBackup, allowSourceFallback bool) (*pb.Backup, error) {
+// KotatsuToMihon converts from Kotatsu backup to protobuf-based Mihon backup.
+// The returned report lists any field that had no exact counterpart in Mihon.
+func KotatsuToMihon(kb *kotatsu.KotatsuBackup, opts Options) (*pb.Backup, *Report, error) {
 	b := &pb.Backup{}
+	report := &Report{}
+	registry := opts.registry()
+	statuses := newStatusTally()
+	tracking := newTrackingTally()
 
-	// Build a map of manga ID -> chapters from the index
-	chaptersByManga := make(map[int64][]*pb.BackupChapter)
-	for _, idx := range kb.Index {
-		var chapters []*pb.BackupChapter
-		for _, kc := range idx.Chapters {
-			chapters = append(chapters, &pb.BackupChapter{
-				Url:            stringPtr(kc.Url),
-				Name:           stringPtr(kc.Name),
-				Scanlator:      stringPtr(kc.Scanlator),
-				Read:           boolPtr(false),
-				Bookmark:       boolPtr(false),
-				LastPageRead:   int64Ptr(0),
-				ChapterNumber:  float32Ptr(kc.Number),
-				DateFetch:      int64Ptr(0),
-				DateUpload:     int64Ptr(kc.UploadDate),
-				SourceOrder:    int64Ptr(0),
-				LastModifiedAt: int64Ptr(0),
-				Version:        int64Ptr(1),
-			})
+	// Build a map of manga ID -> chapters from the chapter lists in the index, if any
+	kotatsuChapters := make(map[int64][]kotatsu.KotatsuChapter)
+	for _, idx := range kb.ChapterIndex {
+		kotatsuChapters[idx.MangaId] = idx.Chapters
+	}
+
+	// Index the reading position of each manga; keep the latest record if a
+	// manga somehow appears more than once
+	historyByManga := make(map[int64]kotatsu.KotatsuHistory)
+	for _, h := range kb.History {
+		if prev, ok := historyByManga[h.MangaId]; !ok || h.UpdatedAt > prev.UpdatedAt {
+			historyByManga[h.MangaId] = h
+		}
+	}
+
+	// Chapters holding at least one page bookmark, per manga
+	bookmarksByManga := make(map[int64]map[int64]bool)
+	for _, bm := range kb.Bookmarks {
+		if bookmarksByManga[bm.MangaId] == nil {
+			bookmarksByManga[bm.MangaId] = make(map[int64]bool)
+		}
+		bookmarksByManga[bm.MangaId][bm.ChapterId] = true
+	}
+
+	// Tracker links, per manga
+	scrobblingByManga := make(map[int64][]kotatsu.KotatsuScrobbling)
+	for _, s := range kb.Scrobbling {
+		scrobblingByManga[s.MangaId] = append(scrobblingByManga[s.MangaId], s)
+	}
+
+	// Convert categories first: Mihon's BackupManga.categories refers to the
+	// category order values, so favourites need to know the order of each id
+	categories, categoryOrder := kotatsuCategoriesToMihon(kb.Categories, report)
+	b.BackupCategories = categories
+
+	// Favourites pointing to a category missing from the backup go to the
+	// default category instead of silently losing their category
+	missing := 0
+	for _, fav := range kb.Favourites {
+		if _, ok := categoryOrder[fav.CategoryId]; !ok {
+			categoryOrder[fav.CategoryId] = mihonDefaultCategory(b, opts.defaultCategory())
+			missing++
 		}
-		chaptersByManga[idx.MangaId] = chapters
+	}
+	if missing > 0 {
+		report.Warnf("%d categories referenced by favourites are missing, their manga were put into %q", missing, opts.defaultCategory())
 	}
 
 	// Track unique sources and build source mapping
 	sourceMap := make(map[string]int64)
 	var backupSources []*pb.BackupSource
 
-	// Convert favourites to mangas with their chapters
-	for _, fav := range kb.Favourites {
-		km := fav.Manga
-
+	// addManga converts a Kotatsu manga with its chapters, reading position,
+	// bookmarks and tracker links
+	mangaByID := make(map[int64]*pb.BackupManga)
+	stubbed, unbookmarked, lostPositions := 0, 0, 0
+	var unnumbered []string // stubbed manga whose current chapter number is unknown
+	addManga := func(km kotatsu.KotatsuManga, favorite bool, dateAdded int64) (*pb.BackupManga, error) {
 		// Generate or retrieve source ID
-		sourceID, err := generateSourceID(km.Source, allowSourceFallback)
+		sourceID, err := generateSourceID(registry, km.Source, opts.AllowSourceFallback)
 		if err != nil {
 			return nil, err
 		}
@@ -142,7 +250,7 @@ func KotatsuToMihon(kb *kotatsu.KotatsuBackup, allowSourceFallback bool) (*pb.Ba
 			sourceMap[km.Source] = sourceID
 			// Try to get the Mihon source name, fall back to Kotatsu name
 			sourceName := km.Source
-			if id, name, found := LookupKnownSource(km.Source); found {
+			if id, name, found := registry.LookupSourceID(km.Source); found {
 				sourceName = name
 				sourceID = id
 			}
@@ -157,39 +265,129 @@ func KotatsuToMihon(kb *kotatsu.KotatsuBackup, allowSourceFallback bool) (*pb.Ba
 			Url:            stringPtr(km.Url),
 			Title:          stringPtr(km.Title),
 			Author:         stringPtr(km.Author),
-			Artist:         stringPtr(""),
-			Description:    stringPtr(""),
-			Genre:          []string{},
-			Status:         int32Ptr(0),
+			Artist:         stringPtr(km.Author), // Kotatsu doesn't tell authors and artists apart
+			Description:    stringPtr(descriptionWithAltTitle(km.Description, km.AltTitle)),
+			Genre:          genreWithContentRating(kotatsuTagsToGenre(km.Tags), km),
+			Status:         int32Ptr(mihonStatusFor(km.State, statuses)),
 			ThumbnailUrl:   stringPtr(km.CoverUrl),
-			DateAdded:      int64Ptr(fav.CreatedAt),
+			DateAdded:      int64Ptr(dateAdded),
 			Viewer:         int32Ptr(0),
-			Chapters:       chaptersByManga[km.Id],
-			Categories:     []int64{fav.CategoryId},
-			Favorite:       boolPtr(true),
+			Categories:     []int64{},
+			Favorite:       boolPtr(favorite),
 			ChapterFlags:   int32Ptr(0),
 			ViewerFlags:    nil,
 			UpdateStrategy: updateStrategyPtr(pb.UpdateStrategy_ALWAYS_UPDATE),
-			LastModifiedAt: int64Ptr(fav.CreatedAt),
+			LastModifiedAt: int64Ptr(dateAdded),
 			Version:        int64Ptr(1),
 			Initialized:    boolPtr(true), // Mark as initialized
 		}
+
+		// Kotatsu backups rarely carry chapter lists; without one, stub chapters
+		// rebuilt from the history keep the reading position
+		kc := kotatsuChapters[km.Id]
+		h, hasHistory := historyByManga[km.Id]
+		if len(kc) == 0 && hasHistory {
+			var numbered bool
+			kc, numbered = historyChapterStubs(h)
+			stubbed++
+			if !numbered {
+				unnumbered = append(unnumbered, km.Title)
+			}
+		}
+		fetchedAt := dateAdded
+		if fetchedAt == 0 && hasHistory {
+			fetchedAt = h.CreatedAt
+		}
+		if fetchedAt == 0 {
+			fetchedAt = time.Now().UnixMilli()
+		}
+		m.Chapters = kotatsuChaptersToMihon(kc, km.Title, fetchedAt)
+		if hasHistory {
+			if !applyKotatsuHistory(m, kc, h) {
+				lostPositions++
+			}
+			applyKotatsuBranch(m, kc, h)
+		}
+		if bookmarked, ok := bookmarksByManga[km.Id]; ok {
+			unbookmarked += applyKotatsuBookmarks(m, kc, bookmarked)
+		}
+		m.Tracking = kotatsuScrobblingToMihon(scrobblingByManga[km.Id], km.Title, tracking)
+		mangaByID[km.Id] = m
 		b.BackupManga = append(b.BackupManga, m)
+		return m, nil
 	}
 
-	// Convert categories
-	for _, c := range kb.Categories {
-		b.BackupCategories = append(b.BackupCategories, &pb.BackupCategory{
-			Name:  stringPtr(c.Title),
-			Order: int64Ptr(c.CreatedAt),
-			Id:    int64Ptr(c.CategoryId),
-			Flags: int64Ptr(0),
-		})
+	// Convert favourites to mangas with their chapters. Kotatsu stores one
+	// favourite per category membership, so favourites of the same manga are
+	// collapsed into a single BackupManga holding all of its categories.
+	for _, fav := range kb.Favourites {
+		km := fav.Manga
+		if km.Id == 0 {
+			km.Id = fav.MangaId
+		}
+
+		m, seen := mangaByID[km.Id]
+		if seen {
+			if fav.CreatedAt > 0 && (m.GetDateAdded() == 0 || fav.CreatedAt < m.GetDateAdded()) {
+				m.DateAdded = int64Ptr(fav.CreatedAt)
+			}
+		} else {
+			var err error
+			if m, err = addManga(km, true, fav.CreatedAt); err != nil {
+				return nil, nil, err
+			}
+		}
+		if order, ok := categoryOrder[fav.CategoryId]; ok && !slices.Contains(m.Categories, order) {
+			m.Categories = append(m.Categories, order)
+		}
+	}
+
+	// Manga that were read or bookmarked but are not in the library are kept as
+	// non-library manga, the way Mihon backs up its own read history. Their
+	// source must be known: an unknown one is reported rather than failing the
+	// conversion over manga the user did not keep.
+	var others []kotatsu.KotatsuManga
+	for _, h := range kb.History {
+		km := h.Manga
+		if km.Id == 0 {
+			km.Id = h.MangaId
+		}
+		others = append(others, km)
+	}
+	for _, bm := range kb.Bookmarks {
+		if km, ok := kb.BookmarkManga[bm.MangaId]; ok {
+			others = append(others, km)
+		}
+	}
+	for _, km := range others {
+		if _, seen := mangaByID[km.Id]; seen {
+			continue
+		}
+		if _, err := addManga(km, false, 0); err != nil {
+			mangaByID[km.Id] = nil
+			report.UnknownSources = append(report.UnknownSources, fmt.Sprintf("%q (%s, not in the library)", km.Title, km.Source))
+		}
+	}
+	for id, bookmarked := range bookmarksByManga {
+		if mangaByID[id] == nil {
+			unbookmarked += len(bookmarked)
+		}
 	}
 
 	// Add the source mappings
 	b.BackupSources = backupSources
 
+	if settings, err := kotatsu.DecodeSettings(kb.RawSettings); err != nil {
+		report.Warnf("settings could not be read: %v", err)
+	} else {
+		b.BackupPreferences = kotatsuSettingsToMihon(settings, report)
+	}
+	if kb.Sources == nil && len(kb.RawSources) > 0 {
+		report.Warnf("the sources section could not be read, pinned and disabled sources were not converted")
+	}
+	b.BackupPreferences = kotatsuSourcesToMihon(kb.Sources, registry, b.BackupPreferences, report)
+	b.BackupSourcePreferences = kotatsuSourceSettingsToMihon(kb.SourceSettings, registry, report)
+
 	// Populate BackupExtensionRepos if there are any sources
 	// This ensures fresh Mihon installs can discover/install required extensions
 	if len(b.BackupSources) > 0 {
@@ -210,6 +408,9 @@ func KotatsuToMihon(kb *kotatsu.KotatsuBackup, allowSourceFallback bool) (*pb.Ba
 		fmt.Printf("📋 Sources in this backup:\n")
 		for i, src := range b.BackupSources {
 			fmt.Printf("   %d. %s (Source ID: %d)\n", i+1, src.GetName(), src.GetSourceId())
+			if ext, found := registry.Extension(src.GetSourceId()); found {
+				fmt.Printf("      Extension: %s (%s)\n", ext.Name, ext.PackageName)
+			}
 		}
 
 		fmt.Printf("\n" + strings.Repeat("=", 60) + "\n")
@@ -236,7 +437,22 @@ func KotatsuToMihon(kb *kotatsu.KotatsuBackup, allowSourceFallback bool) (*pb.Ba
 		fmt.Printf(strings.Repeat("=", 60) + "\n\n")
 	} // Filter out any sources/mangas that are not available in Mihon
 	// pass kb.RawSources (may be empty) so the filter can attempt to read kotatsu-provided list
-	FilterBackupToCommon(b, kb.RawSources)
+	registry.FilterBackupToCommon(b, kb.RawSources)
+
+	if stubbed > 0 {
+		report.Warnf("%d manga had no chapter list; read chapters were rebuilt from history as stubs, which Mihon merges with the real chapters by chapter number (the real chapter URLs are not in the backup) on the first library refresh", stubbed)
+	}
+	if len(unnumbered) > 0 {
+		report.Warnf("%d of them have no chapter count in their history, so their reading position is not carried over: %s", len(unnumbered), strings.Join(unnumbered, ", "))
+	}
+	if lostPositions > 0 {
+		report.Warnf("%d manga were last read on a chapter that is not in their chapter list, their reading position was not converted", lostPositions)
+	}
+	if unbookmarked > 0 {
+		report.Warnf("%d bookmarked chapters are not in a converted chapter list (the manga or its chapter list is missing from the backup), their bookmarks were not converted", unbookmarked)
+	}
+	statuses.report(report)
+	tracking.report(report)
 
-	return b, nil
+	return b, report, nil
 }
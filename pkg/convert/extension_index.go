@@ -1,5 +1,16 @@
 package convert
 
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
 // ExtensionMetadata represents information about a Mihon extension
 type ExtensionMetadata struct {
 	PackageName string // e.g., "eu.kanade.tachiyomi.extension.en.mangadex"
@@ -17,19 +28,121 @@ type SourceInExtension struct {
 	BaseURL string // e.g., "https://mangadex.org"
 }
 
-// KeiyoushiIndex caches the Keiyoushi extension index
-// In a production tool, this would be fetched from:
-// https://raw.githubusercontent.com/keiyoushi/extensions/repo/index.min.json
-// and cached locally or embedded in the binary
-var KeiyoushiIndex = map[int64]ExtensionMetadata{
-	// This would be populated from the index.min.json
-	// For now, we'll use the known mappings approach
-	// TODO: Implement index fetching and parsing
+// KeiyoushiIndexURL is where the Keiyoushi extension repository publishes its
+// index. tools/update-index refreshes the embedded snapshot from it.
+const KeiyoushiIndexURL = "https://raw.githubusercontent.com/keiyoushi/extensions/repo/index.min.json"
+
+//go:embed data/keiyoushi_index.min.json
+var keiyoushiIndexSnapshot []byte
+
+//go:embed data/keiyoushi_index.date
+var keiyoushiIndexSnapshotDate string
+
+// KeiyoushiIndexSnapshotDate returns the date (YYYY-MM-DD) the embedded
+// snapshot of the Keiyoushi index was taken, or "" if no snapshot is embedded.
+func KeiyoushiIndexSnapshotDate() string {
+	return strings.TrimSpace(keiyoushiIndexSnapshotDate)
+}
+
+// KeiyoushiIndex caches the Keiyoushi extension index, keyed by source ID. It
+// is loaded from the snapshot embedded in the binary, so offline builds work;
+// LoadExtensionIndex reads a newer index.
+//
+// Deprecated: the index is copied into DefaultSourceRegistry on its first use
+// and later changes are not seen. Use SourceRegistry.RegisterExtensions and
+// SourceRegistry.Extension instead.
+var KeiyoushiIndex = mustIndexBySourceID(keiyoushiIndexSnapshot)
+
+func mustIndexBySourceID(data []byte) map[int64]ExtensionMetadata {
+	exts, err := ParseExtensionIndex(bytes.NewReader(data))
+	if err != nil {
+		panic("convert: embedded extension index: " + err.Error())
+	}
+	index := make(map[int64]ExtensionMetadata)
+	for _, ext := range exts {
+		for _, s := range ext.Sources {
+			index[s.ID] = ext
+		}
+	}
+	return index
+}
+
+// indexExtension is an extension as listed in a repository's index.min.json.
+type indexExtension struct {
+	Name    string        `json:"name"`
+	Pkg     string        `json:"pkg"`
+	Lang    string        `json:"lang"`
+	Version string        `json:"version"`
+	Sources []indexSource `json:"sources"`
+}
+
+type indexSource struct {
+	Name    string        `json:"name"`
+	Lang    string        `json:"lang"`
+	ID      indexSourceID `json:"id"`
+	BaseURL string        `json:"baseUrl"`
+}
+
+// indexSourceID is a source ID, which repository indexes write as a string
+// since it does not fit in a JavaScript number.
+type indexSourceID int64
+
+func (id *indexSourceID) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("source id %s: %w", data, err)
+	}
+	*id = indexSourceID(v)
+	return nil
+}
+
+// ParseExtensionIndex reads an extension repository index (index.min.json):
+// an array of extensions with their package name, version and sources.
+func ParseExtensionIndex(r io.Reader) ([]ExtensionMetadata, error) {
+	var entries []indexExtension
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	exts := make([]ExtensionMetadata, 0, len(entries))
+	for _, e := range entries {
+		ext := ExtensionMetadata{
+			PackageName: e.Pkg,
+			Name:        e.Name,
+			Lang:        e.Lang,
+			Version:     e.Version,
+		}
+		for _, s := range e.Sources {
+			ext.Sources = append(ext.Sources, SourceInExtension{
+				Name:    s.Name,
+				Lang:    s.Lang,
+				ID:      int64(s.ID),
+				BaseURL: s.BaseURL,
+			})
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+// LoadExtensionIndex reads an extension repository index from a file.
+func LoadExtensionIndex(path string) ([]ExtensionMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	exts, err := ParseExtensionIndex(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return exts, nil
 }
 
-// GetExtensionForSource returns the extension package name for a given source ID
+// GetExtensionForSource returns the extension package name for a given source
+// ID, as known to DefaultSourceRegistry.
 func GetExtensionForSource(sourceID int64) (packageName string, found bool) {
-	ext, found := KeiyoushiIndex[sourceID]
+	ext, found := DefaultSourceRegistry().Extension(sourceID)
 	if !found {
 		return "", false
 	}
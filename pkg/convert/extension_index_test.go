@@ -0,0 +1,59 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExtensionIndex(t *testing.T) {
+	const index = `[
+		{"name":"Tachiyomi: Example","pkg":"eu.kanade.tachiyomi.extension.en.example","apk":"example.apk","lang":"en","code":12,"version":"1.4.12","nsfw":0,
+		 "sources":[
+			{"name":"Example","lang":"en","id":"9223372036854775807","baseUrl":"https://example.org"},
+			{"name":"Example (Mirror)","lang":"en","id":1234,"baseUrl":"https://mirror.example.org"}
+		 ]},
+		{"name":"Tachiyomi: No Sources","pkg":"eu.kanade.tachiyomi.extension.all.nosources","lang":"all","version":"1.4.1"}
+	]`
+	exts, err := ParseExtensionIndex(strings.NewReader(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exts) != 2 {
+		t.Fatalf("got %d extensions, want 2", len(exts))
+	}
+
+	ext := exts[0]
+	if ext.PackageName != "eu.kanade.tachiyomi.extension.en.example" || ext.Name != "Tachiyomi: Example" || ext.Lang != "en" || ext.Version != "1.4.12" {
+		t.Errorf("extension = %+v", ext)
+	}
+	if len(ext.Sources) != 2 {
+		t.Fatalf("got %d sources, want 2", len(ext.Sources))
+	}
+	// IDs above 2^53 are written as strings and must not lose precision
+	want := SourceInExtension{Name: "Example", Lang: "en", ID: 9223372036854775807, BaseURL: "https://example.org"}
+	if ext.Sources[0] != want {
+		t.Errorf("source = %+v, want %+v", ext.Sources[0], want)
+	}
+	if ext.Sources[1].ID != 1234 {
+		t.Errorf("bare number ID = %d, want 1234", ext.Sources[1].ID)
+	}
+
+	if len(exts[1].Sources) != 0 {
+		t.Errorf("extension without sources has %d sources", len(exts[1].Sources))
+	}
+}
+
+func TestParseExtensionIndexBadSourceID(t *testing.T) {
+	for _, id := range []string{`"abc"`, `"12.5"`, `"99999999999999999999"`, `null`} {
+		index := `[{"name":"x","pkg":"x","lang":"en","version":"1","sources":[{"name":"x","lang":"en","id":` + id + `}]}]`
+		if _, err := ParseExtensionIndex(strings.NewReader(index)); err == nil {
+			t.Errorf("source id %s: no error", id)
+		}
+	}
+}
+
+func TestParseExtensionIndexNotAnArray(t *testing.T) {
+	if _, err := ParseExtensionIndex(strings.NewReader(`{"sources":[]}`)); err == nil {
+		t.Error("object index: no error")
+	}
+}
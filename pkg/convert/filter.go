@@ -9,12 +9,20 @@ import (
 	pb "github.com/galpt/mk-bkconv/proto/mihon"
 )
 
+// FilterBackupToCommon removes mangas and sources from the Mihon backup
+// that don't have matching sources available in both Kotatsu and Mihon,
+// using DefaultSourceRegistry. See SourceRegistry.FilterBackupToCommon.
+func FilterBackupToCommon(b *pb.Backup, kotatsuRawSources []byte) {
+	DefaultSourceRegistry().FilterBackupToCommon(b, kotatsuRawSources)
+}
+
 // FilterBackupToCommon removes mangas and sources from the Mihon backup
 // that don't have matching sources available in both Kotatsu and Mihon.
 // It attempts to discover Mihon extension names from a references folder
 // (ENV "REFERENCES_ROOT" or ../references by default). If discovery fails
-// it falls back to KnownSourceMapping as a conservative whitelist.
-func FilterBackupToCommon(b *pb.Backup, kotatsuRawSources []byte) {
+// it falls back to the registered mappings as a conservative whitelist.
+func (r *SourceRegistry) FilterBackupToCommon(b *pb.Backup, kotatsuRawSources []byte) {
+	knownMappings := r.Snapshot()
 	// Discover mihon sources from references (best-effort)
 	refRoot := os.Getenv("REFERENCES_ROOT")
 	if refRoot == "" {
@@ -29,8 +37,8 @@ func FilterBackupToCommon(b *pb.Backup, kotatsuRawSources []byte) {
 	}
 
 	mihonNames := make(map[string]struct{})
-	// Seed from KnownSourceMapping values (guaranteed known mappings)
-	for _, m := range KnownSourceMapping {
+	// Seed from knownMappings values (guaranteed known mappings)
+	for _, m := range knownMappings {
 		mihonNames[strings.ToLower(m.MihonName)] = struct{}{}
 	}
 
@@ -59,9 +67,9 @@ func FilterBackupToCommon(b *pb.Backup, kotatsuRawSources []byte) {
 
 	// Build allowed ID set from mihonNames using GenerateMihonSourceID where possible
 	allowedIDs := make(map[int64]struct{})
-	for _, m := range KnownSourceMapping {
+	for _, m := range knownMappings {
 		if _, ok := mihonNames[strings.ToLower(m.MihonName)]; ok {
-			id := GenerateMihonSourceID(m.MihonName, m.MihonLang, m.MihonVersionID)
+			id := m.SourceID()
 			allowedIDs[id] = struct{}{}
 		}
 	}
@@ -79,11 +87,11 @@ func FilterBackupToCommon(b *pb.Backup, kotatsuRawSources []byte) {
 		}
 	}
 
-	// If allowedIDs is empty, fall back to allowing all KnownSourceMapping IDs
+	// If allowedIDs is empty, fall back to allowing all knownMappings IDs
 	if len(allowedIDs) == 0 {
-		for k := range KnownSourceMapping {
-			m := KnownSourceMapping[k]
-			id := GenerateMihonSourceID(m.MihonName, m.MihonLang, m.MihonVersionID)
+		for k := range knownMappings {
+			m := knownMappings[k]
+			id := m.SourceID()
 			allowedIDs[id] = struct{}{}
 		}
 	}
@@ -129,11 +137,19 @@ func FilterBackupToCommon(b *pb.Backup, kotatsuRawSources []byte) {
 	b.BackupSources = keptSources
 }
 
+// FilterMihonForKotatsu removes Mihon backup entries that don't have a corresponding
+// Kotatsu source available, using DefaultSourceRegistry. See
+// SourceRegistry.FilterMihonForKotatsu.
+func FilterMihonForKotatsu(b *pb.Backup) {
+	DefaultSourceRegistry().FilterMihonForKotatsu(b)
+}
+
 // FilterMihonForKotatsu removes Mihon backup entries that don't have a corresponding
 // Kotatsu source available. It attempts to discover Kotatsu parser names from
 // references (ENV "REFERENCES_ROOT" or ../references by default) and falls back
-// to KnownSourceMapping keys if discovery fails.
-func FilterMihonForKotatsu(b *pb.Backup) {
+// to the registered mapping keys if discovery fails.
+func (r *SourceRegistry) FilterMihonForKotatsu(b *pb.Backup) {
+	knownMappings := r.Snapshot()
 	refRoot := os.Getenv("REFERENCES_ROOT")
 	if refRoot == "" {
 		cwd, err := os.Getwd()
@@ -146,8 +162,8 @@ func FilterMihonForKotatsu(b *pb.Backup) {
 	}
 
 	kotatsuNames := make(map[string]struct{})
-	// Seed from KnownSourceMapping keys
-	for k := range KnownSourceMapping {
+	// Seed from knownMappings keys
+	for k := range knownMappings {
 		kotatsuNames[strings.ToLower(k)] = struct{}{}
 	}
 
@@ -173,12 +189,12 @@ func FilterMihonForKotatsu(b *pb.Backup) {
 		})
 	}
 
-	// Build allowed Mihon IDs for kotatsu-supported sources via KnownSourceMapping
+	// Build allowed Mihon IDs for kotatsu-supported sources via knownMappings
 	allowedIDs := make(map[int64]struct{})
-	for k := range KnownSourceMapping {
+	for k := range knownMappings {
 		if _, ok := kotatsuNames[strings.ToLower(k)]; ok {
-			m := KnownSourceMapping[k]
-			id := GenerateMihonSourceID(m.MihonName, m.MihonLang, m.MihonVersionID)
+			m := knownMappings[k]
+			id := m.SourceID()
 			allowedIDs[id] = struct{}{}
 		}
 	}
@@ -188,6 +204,16 @@ func FilterMihonForKotatsu(b *pb.Backup) {
 		return
 	}
 
+	// Sources whose ID differs from the mapping (other language or version) are
+	// still kept when their name maps back to a supported Kotatsu source
+	for _, s := range b.BackupSources {
+		if k, found := r.ReverseLookup(s.GetSourceId(), s.GetName()); found {
+			if _, ok := kotatsuNames[strings.ToLower(k)]; ok {
+				allowedIDs[s.GetSourceId()] = struct{}{}
+			}
+		}
+	}
+
 	// Filter BackupManga and BackupSources
 	var kept []*pb.BackupManga
 	for _, m := range b.BackupManga {
@@ -0,0 +1,128 @@
+package convert
+
+import (
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+)
+
+// kotatsuProgressUnknown is the value Kotatsu stores in history.percent when the
+// overall progress of a manga cannot be computed.
+const kotatsuProgressUnknown float32 = -1
+
+// mihonHistoryToKotatsu builds the Kotatsu history record for a Mihon manga.
+// The chapter with the most recent BackupHistory.LastRead becomes the current
+// chapter; created_at and updated_at are the oldest and newest LastRead values.
+// As Kotatsu shows the branch of the current chapter, chapters of excluded
+// scanlators only become current when nothing else was read.
+// Returns false if the manga has never been read.
+func mihonHistoryToKotatsu(m *pb.BackupManga, km kotatsu.KotatsuManga) (kotatsu.KotatsuHistory, bool) {
+	excluded := excludedScanlators(m)
+	scanlator := make(map[string]string, len(m.GetChapters()))
+	for _, c := range m.GetChapters() {
+		scanlator[c.GetUrl()] = c.GetScanlator()
+	}
+
+	var currentURL string
+	var currentExcluded bool
+	var createdAt, updatedAt, currentRead int64
+	for _, h := range m.GetHistory() {
+		lastRead := h.GetLastRead()
+		if lastRead <= 0 {
+			continue
+		}
+		if createdAt == 0 || lastRead < createdAt {
+			createdAt = lastRead
+		}
+		updatedAt = max(updatedAt, lastRead)
+		isExcluded := excluded[scanlator[h.GetUrl()]]
+		if currentURL == "" || (currentExcluded && !isExcluded) ||
+			(currentExcluded == isExcluded && lastRead > currentRead) {
+			currentURL, currentExcluded, currentRead = h.GetUrl(), isExcluded, lastRead
+		}
+	}
+	if currentURL == "" {
+		return kotatsu.KotatsuHistory{}, false
+	}
+
+	h := kotatsu.KotatsuHistory{
+		MangaId:   km.Id,
+		CreatedAt: createdAt,
+		UpdatedAt: updatedAt,
+		ChapterId: generateChapterID(km.Source, currentURL),
+		Percent:   kotatsuProgressUnknown,
+		Chapters:  -1,
+		Manga:     km,
+	}
+	if n := len(m.GetChapters()); n > 0 {
+		h.Chapters = n
+	}
+
+	// Kotatsu's percent is the progress over the whole manga, so locate the
+	// current chapter in the chapter list, oldest first
+	chapters := m.GetChapters()
+	for i, ci := range oldestFirst(mihonChapterOrderKeys(chapters)) {
+		c := chapters[ci]
+		if c.GetUrl() != currentURL {
+			continue
+		}
+		h.Page = int(c.GetLastPageRead())
+		done := i
+		if c.GetRead() {
+			done++
+		}
+		h.Percent = float32(done) / float32(len(chapters))
+		break
+	}
+
+	return h, true
+}
+
+// applyKotatsuHistory marks the chapters of a converted Mihon manga according to
+// its Kotatsu history record. kc must be the Kotatsu chapter list m.Chapters was
+// built from, in the same order. Every chapter before the current one is marked
+// read, the current chapter keeps its page, and a BackupHistory entry records
+// when it was last read. It returns false, changing nothing, when the current
+// chapter is not in the chapter list.
+func applyKotatsuHistory(m *pb.BackupManga, kc []kotatsu.KotatsuChapter, h kotatsu.KotatsuHistory) bool {
+	current := -1
+	for i, c := range kc {
+		if c.Id == h.ChapterId {
+			current = i
+			break
+		}
+	}
+	if current < 0 || len(kc) != len(m.Chapters) {
+		return false
+	}
+
+	// Order by chapter number where both numbers are known, falling back to the
+	// position in Kotatsu's list, which is oldest-first. The numbers are taken
+	// from m.Chapters, where missing ones were recognized from chapter names
+	curNumber := m.Chapters[current].GetChapterNumber()
+	for i, c := range m.Chapters {
+		before := i < current
+		if n := c.GetChapterNumber(); n > 0 && curNumber > 0 && n != curNumber {
+			before = n < curNumber
+		}
+		if before {
+			m.Chapters[i].Read = boolPtr(true)
+		}
+	}
+
+	ch := m.Chapters[current]
+	ch.LastPageRead = int64Ptr(int64(h.Page))
+	// percent covers the whole manga, so a full bar means the current chapter was finished too
+	if h.Percent >= 1 {
+		ch.Read = boolPtr(true)
+	}
+
+	lastRead := h.UpdatedAt
+	if lastRead == 0 {
+		lastRead = h.CreatedAt
+	}
+	m.History = append(m.History, &pb.BackupHistory{
+		Url:      stringPtr(ch.GetUrl()),
+		LastRead: int64Ptr(lastRead),
+	})
+	return true
+}
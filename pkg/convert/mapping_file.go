@@ -0,0 +1,132 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MappingOverride is a source mapping loaded from a file.
+type MappingOverride struct {
+	KotatsuKey string
+	Mapping    SourceMapping
+}
+
+// mappingFileEntry is one row of a source mapping file. The columns are the
+// ones tools/mapping_review writes.
+type mappingFileEntry struct {
+	KotatsuKey     string
+	MihonName      string
+	MihonLang      string
+	MihonVersionID int
+	MihonSourceID  int64
+	Notes          string
+}
+
+// LoadSourceMappings reads source mappings from a JSON or CSV file. JSON files
+// hold an array of objects and CSV files a header row, both using the columns
+// KotatsuKey, MihonName, MihonLang, MihonVersionID and optionally MihonSourceID
+// and Notes. MihonLang defaults to "all" and MihonVersionID to 1.
+func LoadSourceMappings(path string) ([]MappingOverride, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []mappingFileEntry
+	ext := strings.ToLower(filepath.Ext(path))
+	trimmed := bytes.TrimSpace(data)
+	if ext == ".json" || ext != ".csv" && len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", path, err)
+		}
+	} else {
+		if entries, err = decodeMappingCSV(data); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", path, err)
+		}
+	}
+
+	out := make([]MappingOverride, 0, len(entries))
+	for i, e := range entries {
+		if e.KotatsuKey == "" || e.MihonName == "" {
+			return nil, fmt.Errorf("decode %s: entry %d needs both KotatsuKey and MihonName", path, i+1)
+		}
+		if e.MihonLang == "" {
+			e.MihonLang = "all"
+		}
+		if e.MihonVersionID == 0 {
+			e.MihonVersionID = 1
+		}
+		out = append(out, MappingOverride{
+			KotatsuKey: e.KotatsuKey,
+			Mapping: SourceMapping{
+				MihonName:      e.MihonName,
+				MihonLang:      e.MihonLang,
+				MihonVersionID: e.MihonVersionID,
+				MihonSourceID:  e.MihonSourceID,
+				Notes:          e.Notes,
+			},
+		})
+	}
+	return out, nil
+}
+
+// decodeMappingCSV reads mapping rows, finding the columns by their header.
+func decodeMappingCSV(data []byte) ([]mappingFileEntry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	col := make(map[string]int)
+	for i, h := range rows[0] {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"kotatsukey", "mihonname"} {
+		if _, ok := col[required]; !ok {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var entries []mappingFileEntry
+	for n, row := range rows[1:] {
+		e := mappingFileEntry{
+			KotatsuKey: field(row, "kotatsukey"),
+			MihonName:  field(row, "mihonname"),
+			MihonLang:  field(row, "mihonlang"),
+			Notes:      field(row, "notes"),
+		}
+		if e.KotatsuKey == "" && e.MihonName == "" {
+			continue // blank line
+		}
+		if v := field(row, "mihonversionid"); v != "" {
+			if e.MihonVersionID, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("row %d: MihonVersionID: %w", n+2, err)
+			}
+		}
+		if v := field(row, "mihonsourceid"); v != "" {
+			if e.MihonSourceID, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return nil, fmt.Errorf("row %d: MihonSourceID: %w", n+2, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,92 @@
+package convert
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeMappingFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSourceMappings(t *testing.T) {
+	tests := []struct {
+		name, file, data string
+		want             []MappingOverride
+	}{
+		{
+			name: "CSV header in another case and order",
+			file: "mappings.csv",
+			data: "mihonname,KOTATSUKEY,MihonLang,mihonVersionId,MIHONSOURCEID,notes\n" +
+				"MangaDex,MANGADEX,en,2,2499283573021220255,official\n",
+			want: []MappingOverride{{KotatsuKey: "MANGADEX", Mapping: SourceMapping{
+				MihonName: "MangaDex", MihonLang: "en", MihonVersionID: 2, MihonSourceID: 2499283573021220255, Notes: "official"}}},
+		},
+		{
+			name: "CSV without optional columns takes the defaults",
+			file: "mappings.csv",
+			data: "KotatsuKey,MihonName\nMANGADEX,MangaDex\n\nMANGAPARK, MangaPark \n",
+			want: []MappingOverride{
+				{KotatsuKey: "MANGADEX", Mapping: SourceMapping{MihonName: "MangaDex", MihonLang: "all", MihonVersionID: 1}},
+				{KotatsuKey: "MANGAPARK", Mapping: SourceMapping{MihonName: "MangaPark", MihonLang: "all", MihonVersionID: 1}},
+			},
+		},
+		{
+			name: "CSV with empty optional fields takes the defaults",
+			file: "mappings.csv",
+			data: "KotatsuKey,MihonName,MihonLang,MihonVersionID,MihonSourceID\nMANGADEX,MangaDex,,,\n",
+			want: []MappingOverride{{KotatsuKey: "MANGADEX", Mapping: SourceMapping{MihonName: "MangaDex", MihonLang: "all", MihonVersionID: 1}}},
+		},
+		{
+			name: "JSON",
+			file: "mappings.json",
+			data: `[{"KotatsuKey":"MANGADEX","MihonName":"MangaDex","MihonLang":"en","MihonVersionID":1,"MihonSourceID":2499283573021220255,"Notes":"n"},
+				{"kotatsukey":"MANGAPARK","mihonname":"MangaPark"}]`,
+			want: []MappingOverride{
+				{KotatsuKey: "MANGADEX", Mapping: SourceMapping{MihonName: "MangaDex", MihonLang: "en", MihonVersionID: 1, MihonSourceID: 2499283573021220255, Notes: "n"}},
+				{KotatsuKey: "MANGAPARK", Mapping: SourceMapping{MihonName: "MangaPark", MihonLang: "all", MihonVersionID: 1}},
+			},
+		},
+		{
+			name: "JSON without a .json extension",
+			file: "mappings.txt",
+			data: `[{"KotatsuKey":"MANGADEX","MihonName":"MangaDex"}]`,
+			want: []MappingOverride{{KotatsuKey: "MANGADEX", Mapping: SourceMapping{MihonName: "MangaDex", MihonLang: "all", MihonVersionID: 1}}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := LoadSourceMappings(writeMappingFile(t, tt.file, tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadSourceMappingsErrors(t *testing.T) {
+	tests := []struct {
+		name, file, data string
+	}{
+		{"CSV bad MihonVersionID", "mappings.csv", "KotatsuKey,MihonName,MihonVersionID\nMANGADEX,MangaDex,one\n"},
+		{"CSV bad MihonSourceID", "mappings.csv", "KotatsuKey,MihonName,MihonSourceID\nMANGADEX,MangaDex,12x\n"},
+		{"CSV missing MihonName column", "mappings.csv", "KotatsuKey,MihonLang\nMANGADEX,en\n"},
+		{"CSV row without MihonName", "mappings.csv", "KotatsuKey,MihonName\nMANGADEX,\n"},
+		{"JSON bad MihonVersionID", "mappings.json", `[{"KotatsuKey":"MANGADEX","MihonName":"MangaDex","MihonVersionID":"one"}]`},
+		{"JSON entry without KotatsuKey", "mappings.json", `[{"MihonName":"MangaDex"}]`},
+	}
+	for _, tt := range tests {
+		if _, err := LoadSourceMappings(writeMappingFile(t, tt.file, tt.data)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+)
+
+// Kotatsu content ratings, as stored in the manga "content_rating" field
+const (
+	kotatsuRatingSafe       = "SAFE"
+	kotatsuRatingSuggestive = "SUGGESTIVE"
+	kotatsuRatingAdult      = "ADULT"
+)
+
+// kotatsuRatingUnknown is the value Kotatsu stores when a manga has no score.
+const kotatsuRatingUnknown float32 = -1
+
+// altTitlePrefix starts the description line that carries Kotatsu's alternative
+// title in Mihon, which has no field for it.
+const altTitlePrefix = "Alternative title: "
+
+// adultGenre is added to the Mihon genres of manga Kotatsu marks as adult, since
+// Mihon has no content rating field.
+const adultGenre = "Adult"
+
+// Genres that tell the content rating of a Mihon manga, lowercase
+var (
+	adultGenres      = []string{"adult", "hentai", "smut", "erotica", "pornographic", "18+"}
+	suggestiveGenres = []string{"ecchi", "mature", "suggestive"}
+)
+
+// kotatsuAuthor merges Mihon's author and artist into Kotatsu's single author
+// field, skipping the artist when it is the same person.
+func kotatsuAuthor(author, artist string) string {
+	author, artist = strings.TrimSpace(author), strings.TrimSpace(artist)
+	if artist == "" || strings.EqualFold(author, artist) || strings.Contains(author, artist) {
+		return author
+	}
+	if author == "" {
+		return artist
+	}
+	return author + ", " + artist
+}
+
+// contentRatingFromGenre derives Kotatsu's content rating and legacy nsfw flag
+// from Mihon genres. Manga without a telling genre are left unrated.
+func contentRatingFromGenre(genre []string) (rating string, nsfw bool) {
+	for _, g := range genre {
+		g = strings.ToLower(strings.TrimSpace(g))
+		for _, a := range adultGenres {
+			if g == a {
+				return kotatsuRatingAdult, true
+			}
+		}
+		for _, s := range suggestiveGenres {
+			if g == s {
+				rating = kotatsuRatingSuggestive
+			}
+		}
+	}
+	return rating, false
+}
+
+// genreWithContentRating adds the adult genre to Mihon genres when Kotatsu marks
+// the manga as adult and none of its genres say so already.
+func genreWithContentRating(genre []string, km kotatsu.KotatsuManga) []string {
+	if km.ContentRating != kotatsuRatingAdult && !km.Nsfw {
+		return genre
+	}
+	if rating, _ := contentRatingFromGenre(genre); rating == kotatsuRatingAdult {
+		return genre
+	}
+	return append(genre, adultGenre)
+}
+
+// descriptionWithAltTitle appends the alternative title to a Mihon description.
+func descriptionWithAltTitle(description, altTitle string) string {
+	altTitle = strings.TrimSpace(altTitle)
+	if altTitle == "" {
+		return description
+	}
+	if description == "" {
+		return altTitlePrefix + altTitle
+	}
+	return description + "\n\n" + altTitlePrefix + altTitle
+}
+
+// splitAltTitle reverses descriptionWithAltTitle.
+func splitAltTitle(description string) (rest, altTitle string) {
+	i := strings.LastIndex(description, altTitlePrefix)
+	if i < 0 || strings.Contains(description[i:], "\n") || i > 0 && !strings.HasSuffix(description[:i], "\n") {
+		return description, ""
+	}
+	return strings.TrimRight(description[:i], "\n"), description[i+len(altTitlePrefix):]
+}
@@ -0,0 +1,33 @@
+package convert
+
+// DefaultCategoryName is the category that receives uncategorized manga when
+// Options.DefaultCategory is empty.
+const DefaultCategoryName = "Uncategorized"
+
+// Options controls how a conversion deals with data that has no direct
+// counterpart in the target app.
+type Options struct {
+	// AllowSourceFallback lets Kotatsu sources without a known Mihon mapping
+	// fall back to a hashed source ID instead of failing the conversion.
+	AllowSourceFallback bool
+	// DefaultCategory names the category that receives manga without a valid
+	// category. Empty means DefaultCategoryName.
+	DefaultCategory string
+	// Registry provides the source mappings and extension metadata. Nil means
+	// the shared DefaultSourceRegistry.
+	Registry *SourceRegistry
+}
+
+func (o Options) defaultCategory() string {
+	if o.DefaultCategory == "" {
+		return DefaultCategoryName
+	}
+	return o.DefaultCategory
+}
+
+func (o Options) registry() *SourceRegistry {
+	if o.Registry == nil {
+		return DefaultSourceRegistry()
+	}
+	return o.Registry
+}
@@ -0,0 +1,249 @@
+package convert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SourceRegistry holds the source mappings and extension metadata a
+// conversion works with. It is safe for concurrent use, so one registry can be
+// shared by conversions running in parallel while mappings are registered.
+//
+// Mappings without an explicit MihonSourceID take the ID of the extension
+// source with the same name and language, when the registry knows one, so
+// real IDs from the extension index win over derived ones.
+type SourceRegistry struct {
+	mu         sync.RWMutex
+	mappings   map[string]SourceMapping
+	extensions map[int64]ExtensionMetadata
+	sourceIDs  map[string]int64    // extension source IDs by extensionSourceKey
+	reverse    *reverseSourceIndex // rebuilt on every change
+}
+
+func extensionSourceKey(name, lang string) string {
+	return strings.ToLower(name) + "/" + lang
+}
+
+// NewSourceRegistry returns an empty registry.
+func NewSourceRegistry() *SourceRegistry {
+	return &SourceRegistry{
+		mappings:   make(map[string]SourceMapping),
+		extensions: make(map[int64]ExtensionMetadata),
+		sourceIDs:  make(map[string]int64),
+		reverse:    newReverseSourceIndex(nil),
+	}
+}
+
+var (
+	defaultRegistry     *SourceRegistry
+	defaultRegistryOnce sync.Once
+)
+
+// DefaultSourceRegistry returns the registry shared by conversions without
+// Options.Registry and by the package-level helpers. It is built from the
+// built-in tables on first use; mappings registered on it are seen by all of
+// its users.
+func DefaultSourceRegistry() *SourceRegistry {
+	defaultRegistryOnce.Do(func() {
+		defaultRegistry = NewDefaultSourceRegistry()
+	})
+	return defaultRegistry
+}
+
+// NewDefaultSourceRegistry returns a registry holding a copy of the built-in
+// KnownSourceMapping and KeiyoushiIndex tables, taken when it is called.
+func NewDefaultSourceRegistry() *SourceRegistry {
+	r := NewSourceRegistry()
+	for k, m := range KnownSourceMapping {
+		r.mappings[k] = m
+	}
+	for _, ext := range KeiyoushiIndex {
+		r.addExtension(ext)
+	}
+	r.rebuild()
+	return r
+}
+
+// resolve fills in the source ID of m from the extension index, if known.
+// Callers hold r.mu.
+func (r *SourceRegistry) resolve(m SourceMapping) SourceMapping {
+	if m.MihonSourceID == 0 {
+		m.MihonSourceID = r.sourceIDs[extensionSourceKey(m.MihonName, m.MihonLang)]
+	}
+	return m
+}
+
+// rebuild refreshes the reverse index. Callers hold r.mu for writing.
+func (r *SourceRegistry) rebuild() {
+	resolved := make(map[string]SourceMapping, len(r.mappings))
+	for k, m := range r.mappings {
+		resolved[k] = r.resolve(m)
+	}
+	r.reverse = newReverseSourceIndex(resolved)
+}
+
+// addExtension indexes ext by its sources. Callers hold r.mu for writing.
+func (r *SourceRegistry) addExtension(ext ExtensionMetadata) {
+	for _, s := range ext.Sources {
+		r.extensions[s.ID] = ext
+		r.sourceIDs[extensionSourceKey(s.Name, s.Lang)] = s.ID
+	}
+}
+
+// Register adds or replaces the mapping of a Kotatsu source.
+func (r *SourceRegistry) Register(kotatsuSource string, m SourceMapping) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mappings[kotatsuSource] = m
+	r.rebuild()
+}
+
+// RegisterExtensions adds or replaces the metadata of extensions, e.g. from
+// LoadExtensionIndex, indexed by the ID of each of their sources.
+func (r *SourceRegistry) RegisterExtensions(exts ...ExtensionMetadata) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ext := range exts {
+		r.addExtension(ext)
+	}
+	r.rebuild()
+}
+
+// Lookup returns the mapping of a Kotatsu source.
+func (r *SourceRegistry) Lookup(kotatsuSource string) (SourceMapping, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, found := r.mappings[kotatsuSource]
+	if !found {
+		return SourceMapping{}, false
+	}
+	return r.resolve(m), true
+}
+
+// LookupSourceID returns the Mihon source ID and name of a Kotatsu source, like
+// LookupKnownSource.
+func (r *SourceRegistry) LookupSourceID(kotatsuSource string) (sourceID int64, sourceName string, found bool) {
+	m, found := r.Lookup(kotatsuSource)
+	if !found {
+		return 0, "", false
+	}
+	return m.SourceID(), m.MihonName, true
+}
+
+// MihonSources returns the Mihon sources a Kotatsu source corresponds to.
+// Mappings with the language "all" stand for a Kotatsu source covering every
+// language, which Mihon splits into one source per language; those are taken
+// from the extension index, sorted by language, and nil is returned if the
+// index does not list them.
+func (r *SourceRegistry) MihonSources(kotatsuSource string) []SourceInExtension {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, found := r.mappings[kotatsuSource]
+	if !found {
+		return nil
+	}
+	m = r.resolve(m)
+	if m.MihonLang != "all" || m.MihonSourceID != 0 {
+		return []SourceInExtension{{Name: m.MihonName, Lang: m.MihonLang, ID: m.SourceID()}}
+	}
+
+	seen := make(map[int64]bool)
+	var out []SourceInExtension
+	for _, ext := range r.extensions {
+		for _, s := range ext.Sources {
+			if strings.EqualFold(s.Name, m.MihonName) && !seen[s.ID] {
+				seen[s.ID] = true
+				out = append(out, s)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Lang != out[j].Lang {
+			return out[i].Lang < out[j].Lang
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// ReverseLookup returns the Kotatsu source name for a Mihon source. The source
+// ID is tried first; the source name is used when the ID is unknown, e.g. for
+// sources whose language or version differs from the mapping, or when the ID
+// only matches an alias while the name matches the real Kotatsu source.
+// Without a name, the name listed in the extension index is used.
+func (r *SourceRegistry) ReverseLookup(sourceID int64, sourceName string) (kotatsuSource string, found bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if sourceName == "" {
+		// Backups only name the sources of library manga; pinned and hidden
+		// sources are named by the extension index, if it lists them
+		for _, s := range r.extensions[sourceID].Sources {
+			if s.ID == sourceID {
+				sourceName = s.Name
+			}
+		}
+	}
+	return r.reverse.Lookup(sourceID, sourceName)
+}
+
+// Extension returns the metadata of the extension providing a Mihon source.
+func (r *SourceRegistry) Extension(sourceID int64) (ExtensionMetadata, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ext, found := r.extensions[sourceID]
+	return ext, found
+}
+
+// Snapshot returns a copy of the mappings, keyed by Kotatsu source name, with
+// source IDs resolved from the extension index.
+func (r *SourceRegistry) Snapshot() map[string]SourceMapping {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]SourceMapping, len(r.mappings))
+	for k, m := range r.mappings {
+		out[k] = r.resolve(m)
+	}
+	return out
+}
+
+// ApplyMappings registers mappings loaded with LoadSourceMappings, replacing
+// existing entries with the same Kotatsu key. It returns a description of every
+// conflict: an existing entry that was replaced with a different Mihon source,
+// a Kotatsu key listed twice with different values (the last one wins), and a
+// MihonSourceID that differs from the one derived from name, language and
+// version (the explicit ID wins).
+func (r *SourceRegistry) ApplyMappings(overrides []MappingOverride) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var conflicts []string
+	fromFile := make(map[string]SourceMapping)
+	for _, o := range overrides {
+		m := o.Mapping
+		if derived := GenerateMihonSourceID(m.MihonName, m.MihonLang, m.MihonVersionID); m.MihonSourceID != 0 && m.MihonSourceID != derived {
+			conflicts = append(conflicts, fmt.Sprintf("%s: source ID %d does not match %s (%d); using %d",
+				o.KotatsuKey, m.MihonSourceID, describeMapping(m), derived, m.MihonSourceID))
+		}
+		if prev, ok := fromFile[o.KotatsuKey]; ok {
+			if prev.SourceID() != m.SourceID() {
+				conflicts = append(conflicts, fmt.Sprintf("%s: listed more than once, %s replaces %s",
+					o.KotatsuKey, describeMapping(m), describeMapping(prev)))
+			}
+		} else if existing, ok := r.mappings[o.KotatsuKey]; ok && r.resolve(existing).SourceID() != r.resolve(m).SourceID() {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s replaces %s",
+				o.KotatsuKey, describeMapping(m), describeMapping(existing)))
+		}
+		fromFile[o.KotatsuKey] = m
+	}
+	for k, m := range fromFile {
+		r.mappings[k] = m
+	}
+	r.rebuild()
+	return conflicts
+}
+
+func describeMapping(m SourceMapping) string {
+	return fmt.Sprintf("%s/%s/%d", m.MihonName, m.MihonLang, m.MihonVersionID)
+}
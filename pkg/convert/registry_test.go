@@ -0,0 +1,83 @@
+package convert
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestSourceRegistryConcurrentUse registers mappings and extensions while
+// lookups run; run it with -race.
+func TestSourceRegistryConcurrentUse(t *testing.T) {
+	r := NewDefaultSourceRegistry()
+	const n = 50
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			r.Register(fmt.Sprintf("TEST_%d", i), SourceMapping{MihonName: fmt.Sprintf("Test %d", i), MihonLang: "en", MihonVersionID: 1})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			r.ApplyMappings([]MappingOverride{{
+				KotatsuKey: fmt.Sprintf("FILE_%d", i),
+				Mapping:    SourceMapping{MihonName: fmt.Sprintf("File %d", i), MihonLang: "all", MihonVersionID: 1},
+			}})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			r.RegisterExtensions(ExtensionMetadata{
+				PackageName: fmt.Sprintf("eu.kanade.tachiyomi.extension.all.file%d", i),
+				Sources:     []SourceInExtension{{Name: fmt.Sprintf("File %d", i), Lang: "en", ID: int64(i + 1)}},
+			})
+		}
+	}()
+
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				r.Lookup("MANGADEX")
+				r.LookupSourceID(fmt.Sprintf("TEST_%d", i))
+				r.ReverseLookup(int64(i+1), "")
+				r.Extension(int64(i + 1))
+				r.MihonSources(fmt.Sprintf("FILE_%d", i))
+				r.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot := r.Snapshot()
+	for i := 0; i < n; i++ {
+		if _, ok := snapshot[fmt.Sprintf("TEST_%d", i)]; !ok {
+			t.Errorf("TEST_%d is missing", i)
+		}
+		if got := r.MihonSources(fmt.Sprintf("FILE_%d", i)); len(got) != 1 || got[0].ID != int64(i+1) {
+			t.Errorf("MihonSources(FILE_%d) = %+v, want the registered extension source", i, got)
+		}
+	}
+}
+
+// DefaultSourceRegistry is shared by the package-level helpers, which may run
+// while mappings are registered on it.
+func TestDefaultSourceRegistryConcurrentUse(t *testing.T) {
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			DefaultSourceRegistry().Register(fmt.Sprintf("DEFAULT_TEST_%d", g), SourceMapping{MihonName: "Default Test", MihonLang: "en", MihonVersionID: 1})
+			LookupKnownSource("MANGADEX")
+			GetExtensionForSource(GenerateMihonSourceID("MangaDex", "en", 1))
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,76 @@
+package convert
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Report collects what a conversion could not carry over exactly, so the user
+// knows what to check after restoring the backup.
+type Report struct {
+	// UnknownSources lists manga whose source has no known counterpart in the
+	// target app, one human readable line per manga
+	UnknownSources []string
+	// Warnings holds any other lossy or approximate mapping
+	Warnings []string
+}
+
+// Warnf records a warning.
+func (r *Report) Warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Empty reports whether nothing was recorded.
+func (r *Report) Empty() bool {
+	return len(r.UnknownSources) == 0 && len(r.Warnings) == 0
+}
+
+// Print writes the report in the same style as the conversion summary.
+func (r *Report) Print(w io.Writer) {
+	if r.Empty() {
+		return
+	}
+	fmt.Fprintf(w, "\n=== Conversion Report ===\n")
+	if len(r.UnknownSources) > 0 {
+		fmt.Fprintf(w, "⚠️  %d manga have no known source in the target app:\n", len(r.UnknownSources))
+		for _, s := range r.UnknownSources {
+			fmt.Fprintf(w, "   • %s\n", s)
+		}
+	}
+	if len(r.Warnings) > 0 {
+		fmt.Fprintf(w, "⚠️  Approximate or lossy mappings:\n")
+		for _, s := range r.Warnings {
+			fmt.Fprintf(w, "   • %s\n", s)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// lossTally counts lossy mappings so each kind is reported once instead of
+// once per manga.
+type lossTally struct {
+	counts map[string]int
+	suffix string // appended to each message, formatted with its count
+}
+
+func newLossTally(suffix string) *lossTally {
+	return &lossTally{counts: make(map[string]int), suffix: suffix}
+}
+
+// add counts one occurrence of the message built from format and args.
+func (t *lossTally) add(format string, args ...interface{}) {
+	t.counts[fmt.Sprintf(format, args...)]++
+}
+
+// report adds one warning per distinct message to r, in sorted order.
+func (t *lossTally) report(r *Report) {
+	msgs := make([]string, 0, len(t.counts))
+	for msg := range t.counts {
+		msgs = append(msgs, msg)
+	}
+	sort.Strings(msgs)
+	for _, msg := range msgs {
+		r.Warnf("%s"+t.suffix, msg, t.counts[msg])
+	}
+}
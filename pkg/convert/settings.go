@@ -0,0 +1,244 @@
+package convert
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+	"github.com/galpt/mk-bkconv/pkg/mihon"
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+)
+
+// settingRule translates one app setting between Mihon's preference store and
+// Kotatsu's settings section. A conversion returns false when the value has no
+// counterpart in the other app.
+type settingRule struct {
+	mihon     string
+	kotatsu   string
+	toKotatsu func(v interface{}) (interface{}, bool)
+	toMihon   func(v interface{}) (interface{}, bool)
+	// approx describes what is lost when the setting is translated, if anything
+	approx string
+	// langSuffix is set for source settings whose Mihon key ends in "_<lang>";
+	// it returns the suffix for the language of a Mihon source
+	langSuffix func(lang string) string
+}
+
+// Mihon's ReadingMode flag values
+const (
+	mihonReadingModeLeftToRight int32 = 0x1
+	mihonReadingModeRightToLeft int32 = 0x2
+	mihonReadingModeVertical    int32 = 0x3
+	mihonReadingModeWebtoon     int32 = 0x4
+)
+
+// Kotatsu's reader mode combines the layout and the reading direction, so it
+// covers both of Mihon's default reading mode and direction. Mihon's continuous
+// vertical mode has no Kotatsu counterpart.
+var mihonReadingModeToKotatsu = map[int32]string{
+	mihonReadingModeLeftToRight: "STANDARD",
+	mihonReadingModeRightToLeft: "REVERSED",
+	mihonReadingModeVertical:    "VERTICAL",
+	mihonReadingModeWebtoon:     "WEBTOON",
+}
+
+// Mihon's ThemeMode names and Kotatsu's night mode values (AppCompatDelegate)
+var mihonThemeToKotatsu = map[string]string{
+	"SYSTEM": "-1",
+	"LIGHT":  "1",
+	"DARK":   "2",
+}
+
+// mihonDefaultUpdateInterval is the library update interval, in hours, used
+// when Kotatsu's update checks are enabled. Kotatsu schedules them itself.
+const mihonDefaultUpdateInterval int32 = 24
+
+var settingRules = []settingRule{
+	{
+		mihon: "pref_default_reading_mode_key", kotatsu: "reader_mode",
+		toKotatsu: func(v interface{}) (interface{}, bool) {
+			mode, ok := mihonReadingModeToKotatsu[toInt32(v)]
+			return mode, ok
+		},
+		toMihon: func(v interface{}) (interface{}, bool) {
+			s, _ := v.(string)
+			for mode, name := range mihonReadingModeToKotatsu {
+				if name == s {
+					return mode, true
+				}
+			}
+			return nil, false
+		},
+	},
+	{mihon: "incognito_mode", kotatsu: "incognito", toKotatsu: sameBool, toMihon: sameBool},
+	{
+		mihon: "pref_library_update_interval_key", kotatsu: "tracker_enabled",
+		toKotatsu: func(v interface{}) (interface{}, bool) {
+			return toInt32(v) > 0, true
+		},
+		toMihon: func(v interface{}) (interface{}, bool) {
+			enabled, ok := v.(bool)
+			if !ok {
+				return nil, false
+			}
+			if enabled {
+				return mihonDefaultUpdateInterval, true
+			}
+			return int32(0), true
+		},
+		approx: "Kotatsu only turns library update checks on or off; Mihon's update interval is set to 24 hours when they are on",
+	},
+	{mihon: "pref_download_only_over_wifi_key", kotatsu: "downloads_wifi", toKotatsu: sameBool, toMihon: sameBool},
+	{mihon: "pref_keep_screen_on_key", kotatsu: "reader_screen_on", toKotatsu: sameBool, toMihon: sameBool},
+	{
+		mihon: "pref_theme_mode_key", kotatsu: "theme",
+		toKotatsu: func(v interface{}) (interface{}, bool) {
+			s, _ := v.(string)
+			mode, ok := mihonThemeToKotatsu[s]
+			return mode, ok
+		},
+		toMihon: func(v interface{}) (interface{}, bool) {
+			s := toString(v)
+			for theme, mode := range mihonThemeToKotatsu {
+				if mode == s {
+					return theme, true
+				}
+			}
+			return nil, false
+		},
+	},
+	{mihon: "pref_theme_dark_amoled_key", kotatsu: "amoled_theme", toKotatsu: sameBool, toMihon: sameBool},
+}
+
+func sameBool(v interface{}) (interface{}, bool) {
+	b, ok := v.(bool)
+	return b, ok
+}
+
+// toInt32 returns a numeric setting value as an int32, 0 if v is not a number.
+func toInt32(v interface{}) int32 {
+	switch n := v.(type) {
+	case int32:
+		return n
+	case int64:
+		return int32(n)
+	case float32:
+		return int32(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return int32(i)
+	}
+	return 0
+}
+
+// toString returns a string setting value; Kotatsu writes list settings as
+// strings but older backups may hold numbers.
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case json.Number:
+		return s.String()
+	}
+	return ""
+}
+
+// mihonSettingsToKotatsu translates the Mihon app preferences covered by
+// settingRules into Kotatsu's settings section.
+func mihonSettingsToKotatsu(prefs []*pb.BackupPreference, report *Report) map[string]interface{} {
+	settings := make(map[string]interface{})
+	var skipped []string
+	for _, p := range prefs {
+		if p.GetKey() == mihonPinnedSourcesKey || p.GetKey() == mihonHiddenSourcesKey {
+			continue // see mihonSourcesToKotatsu
+		}
+		rule, ok := settingRuleFor(func(r settingRule) string { return r.mihon }, p.GetKey())
+		if !ok {
+			skipped = append(skipped, p.GetKey())
+			continue
+		}
+		v, err := mihon.DecodePreference(p.GetValue())
+		if err != nil {
+			report.Warnf("setting %s could not be read: %v", p.GetKey(), err)
+			continue
+		}
+		out, ok := rule.toKotatsu(v)
+		if !ok {
+			report.Warnf("setting %s = %v has no Kotatsu counterpart", p.GetKey(), v)
+			continue
+		}
+		if rule.approx != "" {
+			report.Warnf("setting %s: %s", p.GetKey(), rule.approx)
+		}
+		settings[rule.kotatsu] = out
+	}
+	reportSkippedSettings(report, "Mihon", "Kotatsu", skipped)
+	return settings
+}
+
+// kotatsuSettingsToMihon translates the Kotatsu settings covered by
+// settingRules into Mihon app preferences.
+func kotatsuSettingsToMihon(settings map[string]interface{}, report *Report) []*pb.BackupPreference {
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var prefs []*pb.BackupPreference
+	var skipped []string
+	for _, k := range keys {
+		rule, ok := settingRuleFor(func(r settingRule) string { return r.kotatsu }, k)
+		if !ok {
+			skipped = append(skipped, k)
+			continue
+		}
+		out, ok := rule.toMihon(settings[k])
+		if !ok {
+			report.Warnf("setting %s = %v has no Mihon counterpart", k, settings[k])
+			continue
+		}
+		var err error
+		if prefs, err = mihon.SetPreference(prefs, rule.mihon, out); err != nil {
+			report.Warnf("setting %s could not be written: %v", k, err)
+			continue
+		}
+		if rule.approx != "" {
+			report.Warnf("setting %s: %s", k, rule.approx)
+		}
+	}
+	reportSkippedSettings(report, "Kotatsu", "Mihon", skipped)
+	return prefs
+}
+
+func settingRuleFor(key func(settingRule) string, k string) (settingRule, bool) {
+	for _, r := range settingRules {
+		if key(r) == k {
+			return r, true
+		}
+	}
+	return settingRule{}, false
+}
+
+// reportSkippedSettings lists settings that have no counterpart in one warning.
+func reportSkippedSettings(report *Report, from, to string, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	report.Warnf("%d %s settings have no %s counterpart and were not converted: %s", len(keys), from, to, strings.Join(keys, ", "))
+}
+
+// encodeKotatsuSettings writes settings as the settings section of kb.
+func encodeKotatsuSettings(kb *kotatsu.KotatsuBackup, settings map[string]interface{}, report *Report) {
+	if len(settings) == 0 {
+		return
+	}
+	raw, err := kotatsu.EncodeSettings(settings)
+	if err != nil {
+		report.Warnf("settings could not be written: %v", err)
+		return
+	}
+	kb.RawSettings = raw
+}
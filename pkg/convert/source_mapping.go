@@ -3,6 +3,7 @@ package convert
 import (
 	"crypto/md5"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -16,6 +17,9 @@ import (
 // 3. Have similar enough behavior that a migration makes sense
 //
 // Users will still need to verify and possibly manually adjust sources after import.
+//
+// Deprecated: the table is copied into DefaultSourceRegistry on its first use
+// and later changes are not seen. Register mappings on a SourceRegistry instead.
 var KnownSourceMapping = map[string]SourceMapping{
 	"MANGADEX": {
 		MihonName:      "MangaDex",
@@ -392,9 +396,18 @@ type SourceMapping struct {
 	MihonName      string // Exact source name as it appears in Mihon
 	MihonLang      string // Language code (e.g., "en", "all")
 	MihonVersionID int    // Version ID (usually 1)
+	MihonSourceID  int64  // Source ID for extensions that hardcode it; 0 derives it from the above
 	Notes          string // Additional notes for users
 }
 
+// SourceID returns the Mihon source ID of the mapping.
+func (m SourceMapping) SourceID() int64 {
+	if m.MihonSourceID != 0 {
+		return m.MihonSourceID
+	}
+	return GenerateMihonSourceID(m.MihonName, m.MihonLang, m.MihonVersionID)
+}
+
 // GenerateMihonSourceID generates a source ID using Mihon's algorithm:
 // MD5("sourcename/lang/versionid")[0:8] as Long with sign bit cleared
 func GenerateMihonSourceID(name, lang string, versionID int) int64 {
@@ -417,10 +430,93 @@ func GenerateMihonSourceID(name, lang string, versionID int) int64 {
 }
 
 // LookupKnownSource attempts to find a known Mihon mapping for a Kotatsu source
+// in DefaultSourceRegistry.
 func LookupKnownSource(kotatsuSource string) (sourceID int64, sourceName string, found bool) {
-	if mapping, exists := KnownSourceMapping[kotatsuSource]; exists {
-		id := GenerateMihonSourceID(mapping.MihonName, mapping.MihonLang, mapping.MihonVersionID)
-		return id, mapping.MihonName, true
+	return DefaultSourceRegistry().LookupSourceID(kotatsuSource)
+}
+
+// reverseSourceIndex maps Mihon sources back to Kotatsu source names. It is a
+// snapshot of the mappings it was built from.
+type reverseSourceIndex struct {
+	byID      map[int64]string
+	byName    map[string]string
+	names     map[string]string // lowercase Mihon name by Kotatsu key
+	canonical map[string]bool
+}
+
+// isCanonicalMapping reports whether a Kotatsu key names the same site as its
+// Mihon source (e.g. MANGADEX -> MangaDex), as opposed to an alias that
+// redirects a dead Kotatsu source to some other Mihon source.
+func isCanonicalMapping(kotatsuKey, mihonName string) bool {
+	norm := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, strings.ToLower(s))
+	}
+	return norm(kotatsuKey) == norm(mihonName)
+}
+
+// newReverseSourceIndex builds a reverse index over mappings. When several
+// Kotatsu sources map to the same Mihon source, canonical mappings win over
+// aliases and ties go to the alphabetically first Kotatsu key, so results don't
+// depend on map iteration order.
+func newReverseSourceIndex(mappings map[string]SourceMapping) *reverseSourceIndex {
+	keys := make([]string, 0, len(mappings))
+	for k := range mappings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	r := &reverseSourceIndex{
+		byID:      make(map[int64]string, len(keys)),
+		byName:    make(map[string]string, len(keys)),
+		names:     make(map[string]string, len(keys)),
+		canonical: make(map[string]bool),
+	}
+	for _, k := range keys {
+		if isCanonicalMapping(k, mappings[k].MihonName) {
+			r.canonical[k] = true
+		}
+	}
+	for _, k := range keys {
+		m := mappings[k]
+		id := m.SourceID()
+		if prev, exists := r.byID[id]; !exists || r.canonical[k] && !r.canonical[prev] {
+			r.byID[id] = k
+		}
+		name := strings.ToLower(m.MihonName)
+		r.names[k] = name
+		if prev, exists := r.byName[name]; !exists || r.canonical[k] && !r.canonical[prev] {
+			r.byName[name] = k
+		}
+	}
+	return r
+}
+
+// Lookup returns the Kotatsu source name for a Mihon source. The source ID is
+// tried first; the BackupSource name is used when the ID is unknown, e.g. for
+// sources whose language or version differs from the mapping, or when the ID
+// only matches an alias while the name matches the real Kotatsu source.
+// Without a name, the Mihon name of the mapping the ID matched is used, so an
+// ID that only matches an alias still resolves to the canonical mapping of the
+// same Mihon source.
+func (r *reverseSourceIndex) Lookup(sourceID int64, sourceName string) (kotatsuSource string, found bool) {
+	byID, idFound := r.byID[sourceID]
+	if sourceName == "" && idFound {
+		sourceName = r.names[byID]
+	}
+	byName, nameFound := "", false
+	if sourceName != "" {
+		byName, nameFound = r.byName[strings.ToLower(sourceName)]
+	}
+	switch {
+	case idFound && (r.canonical[byID] || !nameFound || !r.canonical[byName]):
+		return byID, true
+	case nameFound:
+		return byName, true
 	}
-	return 0, "", false
+	return "", false
 }
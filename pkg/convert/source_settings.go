@@ -0,0 +1,222 @@
+package convert
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/galpt/mk-bkconv/pkg/mihon"
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+)
+
+// mihonSourceKeyPrefix starts BackupSourcePreferences.sourceKey, which is the
+// name of the source's preference file: "source_<source id>".
+const mihonSourceKeyPrefix = "source_"
+
+// commonSourceSettingRules apply to every source known to both apps. Mihon
+// extensions that let the user change the site's address store it as
+// overrideBaseUrl, Kotatsu parsers as the domain config key.
+var commonSourceSettingRules = []settingRule{
+	{
+		mihon: "overrideBaseUrl", kotatsu: "domain",
+		toKotatsu: func(v interface{}) (interface{}, bool) {
+			s, _ := v.(string)
+			if _, host, ok := strings.Cut(s, "://"); ok {
+				s = host
+			}
+			s = strings.TrimSuffix(s, "/")
+			return s, s != ""
+		},
+		toMihon: func(v interface{}) (interface{}, bool) {
+			s, _ := v.(string)
+			return "https://" + s, s != ""
+		},
+	},
+}
+
+// sourceSettingRules holds the settings of individual sources, keyed by
+// Kotatsu source name.
+var sourceSettingRules = map[string][]settingRule{
+	"MANGADEX": {
+		{
+			// Mihon's data saver switch is Kotatsu's choice of image server
+			mihon: "dataSaverV5", kotatsu: "img_server", langSuffix: mangaDexLang,
+			toKotatsu: func(v interface{}) (interface{}, bool) {
+				b, ok := v.(bool)
+				if !ok {
+					return nil, false
+				}
+				if b {
+					return "data-saver", true
+				}
+				return "data", true
+			},
+			toMihon: func(v interface{}) (interface{}, bool) {
+				switch toString(v) {
+				case "data-saver":
+					return true, true
+				case "data":
+					return false, true
+				}
+				return nil, false
+			},
+		},
+	},
+}
+
+// mangaDexLangs holds the MangaDex language codes that differ from the
+// language of the Mihon source reading them.
+var mangaDexLangs = map[string]string{
+	"es-419":  "es-la",
+	"pt-BR":   "pt-br",
+	"zh-Hant": "zh-hk",
+}
+
+// mangaDexLang returns the MangaDex language code of a Mihon MangaDex source,
+// which suffixes the keys of its per-language preferences.
+func mangaDexLang(lang string) string {
+	if dexLang, ok := mangaDexLangs[lang]; ok {
+		return dexLang
+	}
+	return lang
+}
+
+// sourceSettingRuleFor returns the rule translating a Mihon preference key of a
+// Kotatsu source.
+func sourceSettingRuleFor(source, key string) (settingRule, bool) {
+	for _, r := range append(sourceSettingRules[source], commonSourceSettingRules...) {
+		if key == r.mihon || r.langSuffix != nil && strings.HasPrefix(key, r.mihon+"_") {
+			return r, true
+		}
+	}
+	return settingRule{}, false
+}
+
+// sourceConfigRuleFor returns the rule translating a Kotatsu config key of a
+// source.
+func sourceConfigRuleFor(source, key string) (settingRule, bool) {
+	for _, r := range append(sourceSettingRules[source], commonSourceSettingRules...) {
+		if key == r.kotatsu {
+			return r, true
+		}
+	}
+	return settingRule{}, false
+}
+
+// mihonSourceSettingsToKotatsu translates the preferences of Mihon sources that
+// exist in Kotatsu into Kotatsu per-source config, keyed by Kotatsu source name.
+// Kotatsu neither backs up nor restores per-source config, so the result only
+// serves conversions back to Mihon.
+func mihonSourceSettingsToKotatsu(b *pb.Backup, registry *SourceRegistry, report *Report) map[string]map[string]interface{} {
+	sourceNames := make(map[int64]string, len(b.BackupSources))
+	for _, s := range b.BackupSources {
+		sourceNames[s.GetSourceId()] = s.GetName()
+	}
+
+	out := make(map[string]map[string]interface{})
+	for _, sp := range b.BackupSourcePreferences {
+		id, err := strconv.ParseInt(strings.TrimPrefix(sp.GetSourceKey(), mihonSourceKeyPrefix), 10, 64)
+		if err != nil || !strings.HasPrefix(sp.GetSourceKey(), mihonSourceKeyPrefix) {
+			report.Warnf("source settings %s do not belong to a source and were not converted", sp.GetSourceKey())
+			continue
+		}
+		source, found := registry.ReverseLookup(id, sourceNames[id])
+		if !found {
+			report.Warnf("settings of source %d were not converted, the source has no Kotatsu counterpart", id)
+			continue
+		}
+
+		var skipped []string
+		for _, p := range sp.GetPrefs() {
+			rule, ok := sourceSettingRuleFor(source, p.GetKey())
+			if !ok {
+				skipped = append(skipped, p.GetKey())
+				continue
+			}
+			v, err := mihon.DecodePreference(p.GetValue())
+			if err != nil {
+				report.Warnf("%s setting %s could not be read: %v", source, p.GetKey(), err)
+				continue
+			}
+			value, ok := rule.toKotatsu(v)
+			if !ok {
+				report.Warnf("%s setting %s = %v has no Kotatsu counterpart", source, p.GetKey(), v)
+				continue
+			}
+			if out[source] == nil {
+				out[source] = make(map[string]interface{})
+			}
+			out[source][rule.kotatsu] = value
+		}
+		reportSkippedSettings(report, "Mihon "+source, "Kotatsu", skipped)
+	}
+	return out
+}
+
+// kotatsuSourceSettingsToMihon translates Kotatsu per-source config into the
+// preferences of the matching Mihon sources. A Kotatsu source covering every
+// language maps to one Mihon source per language, each of which gets the
+// settings; when those sources are unknown the settings are not converted.
+func kotatsuSourceSettingsToMihon(settings map[string]map[string]interface{}, registry *SourceRegistry, report *Report) []*pb.BackupSourcePreferences {
+	sources := make([]string, 0, len(settings))
+	for s := range settings {
+		sources = append(sources, s)
+	}
+	sort.Strings(sources)
+
+	var out []*pb.BackupSourcePreferences
+	for _, source := range sources {
+		if _, known := registry.Lookup(source); !known {
+			report.Warnf("settings of source %s were not converted, the source has no known Mihon counterpart", source)
+			continue
+		}
+		targets := registry.MihonSources(source)
+		if len(targets) == 0 {
+			report.Warnf("settings of source %s were not converted, its Mihon sources are per language and the languages are unknown (see --extension-index)", source)
+			continue
+		}
+
+		keys := make([]string, 0, len(settings[source]))
+		for k := range settings[source] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		prefs := make([][]*pb.BackupPreference, len(targets))
+		var skipped []string
+		for _, k := range keys {
+			rule, ok := sourceConfigRuleFor(source, k)
+			if !ok {
+				skipped = append(skipped, k)
+				continue
+			}
+			value, ok := rule.toMihon(settings[source][k])
+			if !ok {
+				report.Warnf("%s setting %s = %v has no Mihon counterpart", source, k, settings[source][k])
+				continue
+			}
+			for i, target := range targets {
+				key := rule.mihon
+				if rule.langSuffix != nil {
+					key += "_" + rule.langSuffix(target.Lang)
+				}
+				var err error
+				if prefs[i], err = mihon.SetPreference(prefs[i], key, value); err != nil {
+					report.Warnf("%s setting %s could not be written: %v", source, k, err)
+					break
+				}
+			}
+		}
+		reportSkippedSettings(report, "Kotatsu "+source, "Mihon", skipped)
+		for i, target := range targets {
+			if len(prefs[i]) > 0 {
+				out = append(out, &pb.BackupSourcePreferences{
+					SourceKey: stringPtr(fmt.Sprintf("%s%d", mihonSourceKeyPrefix, target.ID)),
+					Prefs:     prefs[i],
+				})
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+package convert
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+	"github.com/galpt/mk-bkconv/pkg/mihon"
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+)
+
+// Mihon keeps the catalogue state of sources as sets of source ids in its app
+// preferences.
+const (
+	mihonPinnedSourcesKey = "pinned_catalogues"
+	mihonHiddenSourcesKey = "hidden_catalogues"
+)
+
+// mihonSourcesToKotatsu builds Kotatsu's sources section from Mihon's pinned
+// and hidden catalogues. Sources of library manga are enabled too, as newer
+// Kotatsu versions only show sources the user enabled. Sources are ordered
+// pinned first, then by Kotatsu name, since Mihon keeps no manual order.
+func mihonSourcesToKotatsu(b *pb.Backup, registry *SourceRegistry, report *Report) []kotatsu.KotatsuSource {
+	sourceNames := make(map[int64]string, len(b.BackupSources))
+	for _, s := range b.BackupSources {
+		sourceNames[s.GetSourceId()] = s.GetName()
+	}
+
+	state := make(map[string]*kotatsu.KotatsuSource)
+	get := func(name string) *kotatsu.KotatsuSource {
+		if state[name] == nil {
+			state[name] = &kotatsu.KotatsuSource{Source: name, Enabled: true}
+		}
+		return state[name]
+	}
+	for _, m := range b.BackupManga {
+		if name, found := registry.ReverseLookup(m.GetSource(), sourceNames[m.GetSource()]); found {
+			get(name)
+		}
+	}
+
+	unmapped := 0
+	for _, set := range []struct {
+		key   string
+		apply func(s *kotatsu.KotatsuSource)
+	}{
+		{mihonPinnedSourcesKey, func(s *kotatsu.KotatsuSource) { s.Pinned = true }},
+		{mihonHiddenSourcesKey, func(s *kotatsu.KotatsuSource) { s.Enabled = false }},
+	} {
+		v, ok, err := mihon.GetPreference(b.BackupPreferences, set.key)
+		if err != nil {
+			report.Warnf("setting %s could not be read: %v", set.key, err)
+			continue
+		}
+		ids, _ := v.([]string)
+		if !ok {
+			continue
+		}
+		for _, s := range ids {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				unmapped++
+				continue
+			}
+			name, found := registry.ReverseLookup(id, sourceNames[id])
+			if !found {
+				unmapped++
+				continue
+			}
+			set.apply(get(name))
+		}
+	}
+	if unmapped > 0 {
+		report.Warnf("%d pinned or hidden Mihon sources have no Kotatsu counterpart", unmapped)
+	}
+
+	out := make([]kotatsu.KotatsuSource, 0, len(state))
+	for _, s := range state {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Pinned != out[j].Pinned {
+			return out[i].Pinned
+		}
+		return out[i].Source < out[j].Source
+	})
+	for i := range out {
+		out[i].SortKey = i
+	}
+	return out
+}
+
+// kotatsuSourcesToMihon stores the pinned and disabled Kotatsu sources as
+// Mihon's pinned and hidden catalogues, keyed by the Mihon source id of each
+// known source. A Kotatsu source covering every language pins or hides each of
+// its Mihon sources (see SourceRegistry.MihonSources). Kotatsu's manual source
+// order has no Mihon counterpart.
+func kotatsuSourcesToMihon(sources []kotatsu.KotatsuSource, registry *SourceRegistry, prefs []*pb.BackupPreference, report *Report) []*pb.BackupPreference {
+	var pinned, hidden []string
+	unmapped, unknownLangs := 0, 0
+	for _, s := range sources {
+		if !s.Pinned && s.Enabled {
+			continue
+		}
+		if _, found := registry.Lookup(s.Source); !found {
+			unmapped++
+			continue
+		}
+		targets := registry.MihonSources(s.Source)
+		if len(targets) == 0 {
+			unknownLangs++
+			continue
+		}
+		for _, target := range targets {
+			id := strconv.FormatInt(target.ID, 10)
+			if s.Pinned {
+				pinned = append(pinned, id)
+			}
+			if !s.Enabled {
+				hidden = append(hidden, id)
+			}
+		}
+	}
+	if unmapped > 0 {
+		report.Warnf("%d pinned or disabled Kotatsu sources have no known Mihon counterpart", unmapped)
+	}
+	if unknownLangs > 0 {
+		report.Warnf("%d pinned or disabled Kotatsu sources were not converted, their Mihon sources are per language and the languages are unknown (see --extension-index)", unknownLangs)
+	}
+
+	for _, set := range []struct {
+		key string
+		ids []string
+	}{
+		{mihonPinnedSourcesKey, pinned},
+		{mihonHiddenSourcesKey, hidden},
+	} {
+		if len(set.ids) == 0 {
+			continue
+		}
+		var err error
+		if prefs, err = mihon.SetPreference(prefs, set.key, set.ids); err != nil {
+			report.Warnf("setting %s could not be written: %v", set.key, err)
+		}
+	}
+	return prefs
+}
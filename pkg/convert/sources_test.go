@@ -0,0 +1,82 @@
+package convert
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+	"github.com/galpt/mk-bkconv/pkg/mihon"
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+)
+
+// mangaDexExtension lists two of the per-language sources of Mihon's MangaDex
+// extension, whose IDs follow from name, language and version.
+func mangaDexExtension() ExtensionMetadata {
+	ext := ExtensionMetadata{PackageName: "eu.kanade.tachiyomi.extension.all.mangadex", Name: "Tachiyomi: MangaDex", Lang: "all"}
+	for _, lang := range []string{"en", "ja"} {
+		ext.Sources = append(ext.Sources, SourceInExtension{Name: "MangaDex", Lang: lang, ID: GenerateMihonSourceID("MangaDex", lang, 1)})
+	}
+	return ext
+}
+
+func TestKotatsuSourcesToMihonPerLanguage(t *testing.T) {
+	registry := NewDefaultSourceRegistry()
+	registry.RegisterExtensions(mangaDexExtension())
+	sources := []kotatsu.KotatsuSource{{Source: "MANGADEX", Enabled: true, Pinned: true}}
+
+	var report Report
+	prefs := kotatsuSourcesToMihon(sources, registry, nil, &report)
+	v, ok, err := mihon.GetPreference(prefs, mihonPinnedSourcesKey)
+	if err != nil || !ok {
+		t.Fatalf("pinned sources not written: %v", err)
+	}
+	got, _ := v.([]string)
+	slices.Sort(got)
+	want := []string{
+		strconv.FormatInt(GenerateMihonSourceID("MangaDex", "en", 1), 10),
+		strconv.FormatInt(GenerateMihonSourceID("MangaDex", "ja", 1), 10),
+	}
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("pinned = %v, want %v", got, want)
+	}
+}
+
+func TestKotatsuSourcesToMihonUnknownLanguages(t *testing.T) {
+	sources := []kotatsu.KotatsuSource{{Source: "MANGADEX", Enabled: true, Pinned: true}}
+
+	var report Report
+	prefs := kotatsuSourcesToMihon(sources, NewDefaultSourceRegistry(), nil, &report)
+	if _, ok, _ := mihon.GetPreference(prefs, mihonPinnedSourcesKey); ok {
+		t.Error("pinned sources written without knowing the languages")
+	}
+	if len(report.Warnings) != 1 {
+		t.Errorf("warnings = %q, want one", report.Warnings)
+	}
+}
+
+// Pinned sources usually have no library manga, so the backup does not name
+// them; MangaDex (en) must not resolve to an alias that redirects to it.
+func TestMihonSourcesToKotatsuPinnedWithoutName(t *testing.T) {
+	prefs, err := mihon.SetPreference(nil, mihonPinnedSourcesKey, []string{strconv.FormatInt(GenerateMihonSourceID("MangaDex", "en", 1), 10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &pb.Backup{BackupPreferences: prefs}
+
+	for name, registry := range map[string]*SourceRegistry{
+		"default": NewDefaultSourceRegistry(),
+		"extension": func() *SourceRegistry {
+			r := NewDefaultSourceRegistry()
+			r.RegisterExtensions(mangaDexExtension())
+			return r
+		}(),
+	} {
+		var report Report
+		got := mihonSourcesToKotatsu(b, registry, &report)
+		if len(got) != 1 || got[0].Source != "MANGADEX" || !got[0].Pinned {
+			t.Errorf("%s registry: sources = %+v, want MANGADEX pinned", name, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mihon's SManga status values, as stored in BackupManga.status
+const (
+	mihonStatusUnknown            int32 = 0
+	mihonStatusOngoing            int32 = 1
+	mihonStatusCompleted          int32 = 2
+	mihonStatusLicensed           int32 = 3
+	mihonStatusPublishingFinished int32 = 4
+	mihonStatusCancelled          int32 = 5
+	mihonStatusOnHiatus           int32 = 6
+)
+
+var mihonStatusNames = map[int32]string{
+	mihonStatusUnknown:            "unknown",
+	mihonStatusOngoing:            "ongoing",
+	mihonStatusCompleted:          "completed",
+	mihonStatusLicensed:           "licensed",
+	mihonStatusPublishingFinished: "publishing finished",
+	mihonStatusCancelled:          "cancelled",
+	mihonStatusOnHiatus:           "on hiatus",
+}
+
+// Kotatsu's MangaState names, as stored in the manga "state" field
+const (
+	kotatsuStateOngoing    = "ONGOING"
+	kotatsuStateFinished   = "FINISHED"
+	kotatsuStateAbandoned  = "ABANDONED"
+	kotatsuStatePaused     = "PAUSED"
+	kotatsuStateUpcoming   = "UPCOMING"
+	kotatsuStateRestricted = "RESTRICTED"
+)
+
+type kotatsuStatus struct {
+	state string
+	exact bool
+}
+
+type mihonStatus struct {
+	status int32
+	exact  bool
+}
+
+// mihonToKotatsuStatus maps Mihon statuses to Kotatsu states. Kotatsu has no
+// separate "publishing finished", so it becomes FINISHED; "licensed" manga are
+// no longer readable on the source, which is what RESTRICTED means in Kotatsu.
+var mihonToKotatsuStatus = map[int32]kotatsuStatus{
+	mihonStatusUnknown:            {"", true},
+	mihonStatusOngoing:            {kotatsuStateOngoing, true},
+	mihonStatusCompleted:          {kotatsuStateFinished, true},
+	mihonStatusLicensed:           {kotatsuStateRestricted, false},
+	mihonStatusPublishingFinished: {kotatsuStateFinished, false},
+	mihonStatusCancelled:          {kotatsuStateAbandoned, true},
+	mihonStatusOnHiatus:           {kotatsuStatePaused, true},
+}
+
+// kotatsuToMihonStatus maps Kotatsu states to Mihon statuses. Mihon has no
+// "upcoming" status, so those manga end up unknown.
+var kotatsuToMihonStatus = map[string]mihonStatus{
+	"":                     {mihonStatusUnknown, true},
+	kotatsuStateOngoing:    {mihonStatusOngoing, true},
+	kotatsuStateFinished:   {mihonStatusCompleted, true},
+	kotatsuStateAbandoned:  {mihonStatusCancelled, true},
+	kotatsuStatePaused:     {mihonStatusOnHiatus, true},
+	kotatsuStateUpcoming:   {mihonStatusUnknown, false},
+	kotatsuStateRestricted: {mihonStatusLicensed, false},
+}
+
+// statusMapped is the lossTally message of an approximate status mapping.
+const statusMapped = "status %q was mapped to %q"
+
+// newStatusTally returns a tally of approximate status mappings.
+func newStatusTally() *lossTally {
+	return newLossTally(" for %d manga (no exact counterpart)")
+}
+
+// kotatsuState returns the Kotatsu state for a Mihon status.
+func kotatsuState(status int32, tally *lossTally) string {
+	s, ok := mihonToKotatsuStatus[status]
+	if !ok {
+		tally.add(statusMapped, fmt.Sprint(status), "")
+		return ""
+	}
+	if !s.exact {
+		tally.add(statusMapped, mihonStatusNames[status], s.state)
+	}
+	return s.state
+}
+
+// mihonStatusFor returns the Mihon status for a Kotatsu state.
+func mihonStatusFor(state string, tally *lossTally) int32 {
+	s, ok := kotatsuToMihonStatus[strings.ToUpper(state)]
+	if !ok {
+		tally.add(statusMapped, state, mihonStatusNames[mihonStatusUnknown])
+		return mihonStatusUnknown
+	}
+	if !s.exact {
+		tally.add(statusMapped, state, mihonStatusNames[s.status])
+	}
+	return s.status
+}
@@ -0,0 +1,63 @@
+package convert
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+)
+
+// tagKey builds a Kotatsu tag key from a genre title. Most Kotatsu parsers use
+// the site's URL slug as key, so a lowercase, dash-separated slug is the closest
+// guess; Kotatsu replaces the tags with the real ones on the next details refresh.
+func tagKey(title string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(title)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+			dash = false
+		} else if !dash && sb.Len() > 0 {
+			sb.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "-")
+}
+
+// genreToKotatsuTags turns Mihon genre strings into tags of the given Kotatsu
+// source. Empty and duplicate genres are skipped.
+func genreToKotatsuTags(genre []string, source string) []kotatsu.KotatsuTag {
+	tags := []kotatsu.KotatsuTag{}
+	seen := make(map[string]bool)
+	for _, g := range genre {
+		title := strings.TrimSpace(g)
+		key := tagKey(title)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		tags = append(tags, kotatsu.KotatsuTag{
+			Id:     kotatsu.TagId(key, source),
+			Title:  title,
+			Key:    key,
+			Source: source,
+		})
+	}
+	return tags
+}
+
+// kotatsuTagsToGenre returns the tag titles as Mihon genre strings.
+func kotatsuTagsToGenre(tags []kotatsu.KotatsuTag) []string {
+	genre := []string{}
+	for _, t := range tags {
+		title := strings.TrimSpace(t.Title)
+		if title == "" {
+			title = t.Key
+		}
+		if title != "" {
+			genre = append(genre, title)
+		}
+	}
+	return genre
+}
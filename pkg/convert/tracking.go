@@ -0,0 +1,187 @@
+package convert
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/galpt/mk-bkconv/pkg/kotatsu"
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+)
+
+// Mihon tracker ids, as stored in BackupTracking.syncId
+const (
+	mihonTrackerMAL       int32 = 1
+	mihonTrackerAniList   int32 = 2
+	mihonTrackerKitsu     int32 = 3
+	mihonTrackerShikimori int32 = 4
+)
+
+// tracker describes a tracking service known to both apps.
+type tracker struct {
+	name      string
+	scrobbler int     // Kotatsu scrobbler id
+	maxScore  float32 // Mihon stores scores on the service's own scale
+	url       string  // tracking URL format, given the service's manga id
+	// Mihon status value of each Kotatsu scrobbling status, in the
+	// service's own numbering
+	statuses map[string]int32
+}
+
+var trackers = map[int32]tracker{
+	mihonTrackerMAL: {
+		name: "MyAnimeList", scrobbler: kotatsu.ScrobblerMAL, maxScore: 10,
+		url: "https://myanimelist.net/manga/%d",
+		statuses: map[string]int32{
+			kotatsu.ScrobblingReading:   1,
+			kotatsu.ScrobblingCompleted: 2,
+			kotatsu.ScrobblingOnHold:    3,
+			kotatsu.ScrobblingDropped:   4,
+			kotatsu.ScrobblingPlanned:   6,
+			kotatsu.ScrobblingReReading: 7,
+		},
+	},
+	mihonTrackerAniList: {
+		name: "AniList", scrobbler: kotatsu.ScrobblerAniList, maxScore: 100,
+		url: "https://anilist.co/manga/%d",
+		statuses: map[string]int32{
+			kotatsu.ScrobblingReading:   1,
+			kotatsu.ScrobblingPlanned:   2,
+			kotatsu.ScrobblingCompleted: 3,
+			kotatsu.ScrobblingOnHold:    4,
+			kotatsu.ScrobblingDropped:   5,
+			kotatsu.ScrobblingReReading: 6,
+		},
+	},
+	mihonTrackerKitsu: {
+		name: "Kitsu", scrobbler: kotatsu.ScrobblerKitsu, maxScore: 10,
+		url: "https://kitsu.app/manga/%d",
+		// Kitsu has no rereading status
+		statuses: map[string]int32{
+			kotatsu.ScrobblingReading:   1,
+			kotatsu.ScrobblingCompleted: 2,
+			kotatsu.ScrobblingOnHold:    3,
+			kotatsu.ScrobblingDropped:   4,
+			kotatsu.ScrobblingPlanned:   5,
+		},
+	},
+	mihonTrackerShikimori: {
+		name: "Shikimori", scrobbler: kotatsu.ScrobblerShikimori, maxScore: 10,
+		url: "https://shikimori.one/mangas/%d",
+		statuses: map[string]int32{
+			kotatsu.ScrobblingReading:   1,
+			kotatsu.ScrobblingCompleted: 2,
+			kotatsu.ScrobblingOnHold:    3,
+			kotatsu.ScrobblingDropped:   4,
+			kotatsu.ScrobblingPlanned:   5,
+			kotatsu.ScrobblingReReading: 6,
+		},
+	},
+}
+
+// trackerByScrobbler returns the Mihon tracker id of a Kotatsu scrobbler.
+func trackerByScrobbler(scrobbler int) (int32, bool) {
+	for id, t := range trackers {
+		if t.scrobbler == scrobbler {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// newTrackingTally returns a tally of tracking data that could not be carried
+// over.
+func newTrackingTally() *lossTally {
+	return newLossTally(" (%d manga)")
+}
+
+// mihonTrackingToKotatsu converts the tracker links of a Mihon manga. Kotatsu
+// keeps neither reading dates nor the private flag, and only knows the
+// trackers in the trackers table; anything else is counted in tally.
+func mihonTrackingToKotatsu(m *pb.BackupManga, mangaID int64, tally *lossTally) []kotatsu.KotatsuScrobbling {
+	var out []kotatsu.KotatsuScrobbling
+	for _, tr := range m.GetTracking() {
+		t, ok := trackers[tr.GetSyncId()]
+		if !ok {
+			tally.add("tracker %d has no Kotatsu counterpart and was dropped", tr.GetSyncId())
+			continue
+		}
+		targetID := tr.GetMediaId()
+		if targetID == 0 {
+			targetID = int64(tr.GetMediaIdInt())
+		}
+		if targetID == 0 {
+			tally.add("%s links without a media id were dropped", t.name)
+			continue
+		}
+		if tr.GetStartedReadingDate() != 0 || tr.GetFinishedReadingDate() != 0 {
+			tally.add("%s reading dates are not kept by Kotatsu", t.name)
+		}
+
+		status := ""
+		for s, v := range t.statuses {
+			if v == tr.GetStatus() {
+				status = s
+				break
+			}
+		}
+		if status == "" && tr.GetStatus() != 0 {
+			tally.add("%s status %d has no Kotatsu counterpart", t.name, tr.GetStatus())
+		}
+
+		// Kotatsu's list entry id is only needed to update the entry; the
+		// service's manga id is a usable stand-in when Mihon didn't keep one
+		id := tr.GetLibraryId()
+		if id == 0 {
+			id = targetID
+		}
+		out = append(out, kotatsu.KotatsuScrobbling{
+			Scrobbler: t.scrobbler,
+			Id:        id,
+			MangaId:   mangaID,
+			TargetId:  targetID,
+			Status:    status,
+			Chapter:   int(tr.GetLastChapterRead()),
+			Rating:    float32(math.Min(1, math.Max(0, float64(tr.GetScore()/t.maxScore)))),
+		})
+	}
+	return out
+}
+
+// kotatsuScrobblingToMihon converts the scrobbling entries of one manga to
+// Mihon tracker links. Comments have no Mihon counterpart and are dropped.
+func kotatsuScrobblingToMihon(entries []kotatsu.KotatsuScrobbling, title string, tally *lossTally) []*pb.BackupTracking {
+	var out []*pb.BackupTracking
+	for _, s := range entries {
+		syncID, ok := trackerByScrobbler(s.Scrobbler)
+		if !ok {
+			tally.add("scrobbler %d has no Mihon counterpart and was dropped", s.Scrobbler)
+			continue
+		}
+		t := trackers[syncID]
+		status, ok := t.statuses[s.Status]
+		switch {
+		case ok || s.Status == "":
+		case s.Status == kotatsu.ScrobblingReReading:
+			status = t.statuses[kotatsu.ScrobblingReading]
+			tally.add("%s status %s was mapped to %s", t.name, s.Status, kotatsu.ScrobblingReading)
+		default:
+			tally.add("%s status %s has no Mihon counterpart", t.name, s.Status)
+		}
+		if s.Comment != "" {
+			tally.add("%s comments are not kept by Mihon", t.name)
+		}
+		out = append(out, &pb.BackupTracking{
+			SyncId:          int32Ptr(syncID),
+			LibraryId:       int64Ptr(s.Id),
+			MediaIdInt:      int32Ptr(0),
+			MediaId:         int64Ptr(s.TargetId),
+			TrackingUrl:     stringPtr(fmt.Sprintf(t.url, s.TargetId)),
+			Title:           stringPtr(title),
+			LastChapterRead: float32Ptr(float32(s.Chapter)),
+			TotalChapters:   int32Ptr(0),
+			Score:           float32Ptr(float32(math.Round(float64(s.Rating*t.maxScore)*10) / 10)),
+			Status:          int32Ptr(status),
+		})
+	}
+	return out
+}
@@ -0,0 +1,26 @@
+package kotatsu
+
+import "unicode/utf16"
+
+// LongHashCode reproduces Kotatsu's String.longHashCode(): a 64-bit variant of
+// Java's String.hashCode() over the UTF-16 code units of s, seeded with
+// 1125899906842597. Overflow wraps exactly like a Kotlin Long.
+func LongHashCode(s string) int64 {
+	h := int64(1125899906842597)
+	for _, c := range utf16.Encode([]rune(s)) {
+		h = 31*h + int64(c)
+	}
+	return h
+}
+
+// TagId returns the id Kotatsu assigns to a tag of the given source.
+func TagId(key, source string) int64 {
+	return LongHashCode(key + "_" + source)
+}
+
+// GenerateUid reproduces MangaParser.generateUid(url), which Kotatsu parsers use
+// for manga, chapter and page ids: LongHashCode over the source name followed by
+// the url. The url must be in the form the parser stores, usually site-relative.
+func GenerateUid(source, url string) int64 {
+	return LongHashCode(source + url)
+}
@@ -0,0 +1,60 @@
+package kotatsu
+
+import "testing"
+
+// These are regression vectors, not ids taken from Kotatsu: apart from "" (the
+// seed) and "a" (31*1125899906842597 + 97), the expected values were computed
+// with a separate implementation of Kotatsu's String.longHashCode() over UTF-16
+// code units, where characters outside the BMP count as two code units (a
+// surrogate pair), as in Kotlin. They pin the algorithm, not its agreement
+// with ids in real Kotatsu backups.
+func TestLongHashCode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", 1125899906842597},
+		{"a", 34902897112120604},
+		{"MANGADEX", -4898811344270594360},
+		{"Ромком", -1367968379507692289},
+		{"日本語", -3351804022645374062},
+		{"😀", 1081989810477508616},
+		{"𝄞x", -3351804022616351671},
+	}
+	for _, tt := range tests {
+		if got := LongHashCode(tt.in); got != tt.want {
+			t.Errorf("LongHashCode(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateUid(t *testing.T) {
+	tests := []struct {
+		source, url string
+		want        int64
+	}{
+		{"MANGADEX", "/title/a96676e5-8ae2-425e-b549-7f15dd34a6d8", 7864816106371712066},
+		{"READMANGA_RU", "/ромком", -1159190745293773120},
+	}
+	for _, tt := range tests {
+		if got := GenerateUid(tt.source, tt.url); got != tt.want {
+			t.Errorf("GenerateUid(%q, %q) = %d, want %d", tt.source, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestTagId(t *testing.T) {
+	tests := []struct {
+		key, source string
+		want        int64
+	}{
+		{"action", "MANGADEX", 8192124106438577479},
+		{"романтика", "READMANGA_RU", -8673519578047227695},
+		{"😀", "MANGADEX", 1620086203571484218},
+	}
+	for _, tt := range tests {
+		if got := TagId(tt.key, tt.source); got != tt.want {
+			t.Errorf("TagId(%q, %q) = %d, want %d", tt.key, tt.source, got, tt.want)
+		}
+	}
+}
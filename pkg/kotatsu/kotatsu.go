@@ -2,10 +2,12 @@ package kotatsu
 
 import (
 	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"time"
 )
 
 // Minimal Kotatsu models used for conversion
@@ -14,11 +16,47 @@ type KotatsuBackup struct {
 	Categories []KotatsuCategory       `json:"categories"`
 	History    []KotatsuHistory        `json:"history"`
 	Bookmarks  []KotatsuBookmark       `json:"bookmarks"`
-	Index      []KotatsuIndexEntry     `json:"index"`
+	Scrobbling []KotatsuScrobbling     `json:"scrobbling"`
+	Index      *KotatsuIndex           `json:"index"`
+	// Per-manga chapter lists. Kotatsu itself does not back chapters up; they are
+	// read from the index entry of backups that carry them there, or from the
+	// ChaptersEntry this package writes them to
+	ChapterIndex []KotatsuIndexEntry `json:"-"`
+	// Sources section, decoded from RawSources when loading; nil when RawSources
+	// is not in the expected format. It is only written when RawSources is
+	// empty, so loaded backups keep the section byte for byte
+	Sources []KotatsuSource `json:"-"`
+	// Manga of the bookmark groups, keyed by manga id. Written bookmark groups
+	// fall back to them for manga in neither favourites nor history
+	BookmarkManga map[int64]KotatsuManga `json:"-"`
 	// Raw sections (for passthrough)
 	RawSettings   json.RawMessage `json:"-"`
 	RawReaderGrid json.RawMessage `json:"-"`
 	RawSources    json.RawMessage `json:"-"`
+	// Per-source config, keyed by source name and then config key. Kotatsu keeps
+	// it in per-source preferences and does not back it up; it is read from and
+	// written to the SourceSettingsEntry
+	SourceSettings map[string]map[string]interface{} `json:"-"`
+	// Zip entries this package does not know about, kept verbatim in archive order
+	RawEntries []KotatsuRawEntry `json:"-"`
+
+	// Kotatsu sections as loaded, keyed by entry name. A section whose typed
+	// value still encodes the same way when written is written back byte for
+	// byte, so fields the structs do not model survive a load and write
+	loaded map[string]loadedSection
+}
+
+// loadedSection is a section as read by LoadKotatsuZip along with the encoding
+// of its typed value right after loading.
+type loadedSection struct {
+	raw     []byte
+	encoded []byte
+}
+
+// KotatsuRawEntry is a zip entry that is passed through without decoding.
+type KotatsuRawEntry struct {
+	Name string
+	Data []byte
 }
 
 type KotatsuFavouriteEntry struct {
@@ -31,20 +69,33 @@ type KotatsuFavouriteEntry struct {
 }
 
 type KotatsuManga struct {
-	Id            int64         `json:"id"`
-	Title         string        `json:"title"`
-	AltTitle      string        `json:"alt_title"`
-	Url           string        `json:"url"`
-	PublicUrl     string        `json:"public_url"`
-	Rating        float32       `json:"rating"`
-	Nsfw          bool          `json:"nsfw"`
-	ContentRating string        `json:"content_rating"`
-	CoverUrl      string        `json:"cover_url"`
-	LargeCover    string        `json:"large_cover_url"`
-	State         string        `json:"state"`
-	Author        string        `json:"author"`
-	Source        string        `json:"source"`
-	Tags          []interface{} `json:"tags"`
+	Id            int64        `json:"id"`
+	Title         string       `json:"title"`
+	AltTitle      string       `json:"alt_title"`
+	Url           string       `json:"url"`
+	PublicUrl     string       `json:"public_url"`
+	Rating        float32      `json:"rating"`
+	Nsfw          bool         `json:"nsfw"`
+	ContentRating string       `json:"content_rating"`
+	CoverUrl      string       `json:"cover_url"`
+	LargeCover    string       `json:"large_cover_url"`
+	State         string       `json:"state"`
+	Author        string       `json:"author"`
+	Source        string       `json:"source"`
+	Tags          []KotatsuTag `json:"tags"`
+	// Not part of Kotatsu's own backups, which refetch descriptions from the
+	// source; kept so they survive a round trip and ignored by Kotatsu on restore
+	Description string `json:"description,omitempty"`
+}
+
+// KotatsuTag is a genre tag. Keys are specific to the source the tag belongs
+// to; the id is derived from both, see TagId.
+type KotatsuTag struct {
+	Id     int64  `json:"id"`
+	Title  string `json:"title"`
+	Key    string `json:"key"`
+	Source string `json:"source"`
+	Pinned bool   `json:"pinned"`
 }
 
 type KotatsuCategory struct {
@@ -52,16 +103,22 @@ type KotatsuCategory struct {
 	CreatedAt  int64  `json:"created_at"`
 	SortKey    int    `json:"sort_key"`
 	Title      string `json:"title"`
+	// Category options; nil or empty means Kotatsu's default
+	Order     string `json:"order,omitempty"`       // ListSortOrder name, e.g. NEWEST or ALPHABETIC
+	Track     *bool  `json:"track,omitempty"`       // check the category for new chapters
+	ShowInLib *bool  `json:"show_in_lib,omitempty"` // show the category in the library
 }
 
 type KotatsuHistory struct {
-	MangaId   int64   `json:"manga_id"`
-	CreatedAt int64   `json:"created_at"`
-	UpdatedAt int64   `json:"updated_at"`
-	ChapterId int64   `json:"chapter_id"`
-	Page      int     `json:"page"`
-	Scroll    float64 `json:"scroll"`
-	Percent   float32 `json:"percent"`
+	MangaId   int64        `json:"manga_id"`
+	CreatedAt int64        `json:"created_at"`
+	UpdatedAt int64        `json:"updated_at"`
+	ChapterId int64        `json:"chapter_id"`
+	Page      int          `json:"page"`
+	Scroll    float64      `json:"scroll"`
+	Percent   float32      `json:"percent"`
+	Chapters  int          `json:"chapters"` // chapter count, -1 if unknown; missing in older backups
+	Manga     KotatsuManga `json:"manga"`    // Kotatsu restores the manga row from here before the history row
 }
 
 type KotatsuBookmark struct {
@@ -75,6 +132,80 @@ type KotatsuBookmark struct {
 	Percent   float32 `json:"percent"`
 }
 
+// KotatsuSource is the state of a manga source in the catalogue: whether it is
+// enabled, pinned to the top, and its position in the user's ordering.
+type KotatsuSource struct {
+	Source  string `json:"source"`
+	Enabled bool   `json:"enabled"`
+	SortKey int    `json:"sort_key"`
+	AddedIn int    `json:"added_in"` // app version code the source was added in
+	UsedAt  int64  `json:"used_at"`
+	Pinned  bool   `json:"pinned"`
+}
+
+// KotatsuScrobbling links a manga to its entry on a tracking service.
+type KotatsuScrobbling struct {
+	Scrobbler int     `json:"scrobbler"` // see the Scrobbler constants
+	Id        int64   `json:"id"`        // id of the list entry on the service
+	MangaId   int64   `json:"manga_id"`
+	TargetId  int64   `json:"target_id"` // id of the manga on the service
+	Status    string  `json:"status,omitempty"`
+	Chapter   int     `json:"chapter"` // last chapter read
+	Comment   string  `json:"comment,omitempty"`
+	Rating    float32 `json:"rating"` // 0 to 1
+}
+
+// Scrobbler ids, as in Kotatsu's ScrobblerService.
+const (
+	ScrobblerShikimori = 1
+	ScrobblerAniList   = 2
+	ScrobblerMAL       = 3
+	ScrobblerKitsu     = 4
+)
+
+// Scrobbling statuses, as in Kotatsu's ScrobblingStatus.
+const (
+	ScrobblingPlanned   = "PLANNED"
+	ScrobblingReading   = "READING"
+	ScrobblingReReading = "RE_READING"
+	ScrobblingCompleted = "COMPLETED"
+	ScrobblingOnHold    = "ON_HOLD"
+	ScrobblingDropped   = "DROPPED"
+)
+
+// KotatsuBookmarkGroup is how Kotatsu stores the bookmarks section on disk: one
+// element per manga, holding the manga, its tags and all of its page bookmarks.
+// KotatsuBackup.Bookmarks keeps them flattened.
+type KotatsuBookmarkGroup struct {
+	Manga     KotatsuManga      `json:"manga"`
+	Tags      []KotatsuTag      `json:"tags"`
+	Bookmarks []KotatsuBookmark `json:"bookmarks"`
+}
+
+// KotatsuIndex is the backup metadata Kotatsu writes as the first element of
+// the index entry.
+type KotatsuIndex struct {
+	AppId      string `json:"app_id"`
+	AppVersion int    `json:"app_version"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+const (
+	// KotatsuAppID is the application id Kotatsu records in its backups.
+	KotatsuAppID = "org.koitharu.kotatsu"
+	// KotatsuAppVersion is the version code written when the backup did not come
+	// from Kotatsu. Kotatsu only records it and does not check it on restore.
+	KotatsuAppVersion = 700
+	// ChaptersEntry is the zip entry chapter lists are written to. Kotatsu skips
+	// entries it does not know, and keeping them out of the index keeps the index
+	// readable by Kotatsu versions that decode it strictly.
+	ChaptersEntry = "chapters"
+	// SourceSettingsEntry is the zip entry per-source config is written to.
+	SourceSettingsEntry = "source_settings"
+)
+
+// KotatsuIndexEntry is a per-manga chapter list. Some backups carry these next
+// to the metadata in the index entry.
 type KotatsuIndexEntry struct {
 	MangaId  int64            `json:"manga_id"`
 	Chapters []KotatsuChapter `json:"chapters"`
@@ -88,6 +219,7 @@ type KotatsuChapter struct {
 	Scanlator  string  `json:"scanlator"`
 	UploadDate int64   `json:"upload_date"`
 	Branch     string  `json:"branch"`
+	Volume     int     `json:"volume,omitempty"`
 }
 
 // LoadKotatsuZip reads a Kotatsu zip and returns parsed backup data.
@@ -99,6 +231,15 @@ func LoadKotatsuZip(path string) (*KotatsuBackup, error) {
 	defer r.Close()
 
 	kb := &KotatsuBackup{}
+	raw := make(map[string][]byte)
+	readSection := func(rc io.Reader, name string, v interface{}) error {
+		buf, err := io.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		raw[name] = buf
+		return json.Unmarshal(buf, v)
+	}
 	for _, f := range r.File {
 		rc, err := f.Open()
 		if err != nil {
@@ -108,39 +249,71 @@ func LoadKotatsuZip(path string) (*KotatsuBackup, error) {
 		switch f.Name {
 		case "favourites":
 			var arr []KotatsuFavouriteEntry
-			if err := json.NewDecoder(rc).Decode(&arr); err != nil {
+			if err := readSection(rc, f.Name, &arr); err != nil {
 				rc.Close()
 				return nil, fmt.Errorf("decode favourites: %w", err)
 			}
 			kb.Favourites = arr
 		case "categories":
 			var arr []KotatsuCategory
-			if err := json.NewDecoder(rc).Decode(&arr); err != nil {
+			if err := readSection(rc, f.Name, &arr); err != nil {
 				rc.Close()
 				return nil, fmt.Errorf("decode categories: %w", err)
 			}
 			kb.Categories = arr
 		case "history":
 			var arr []KotatsuHistory
-			if err := json.NewDecoder(rc).Decode(&arr); err != nil {
+			if err := readSection(rc, f.Name, &arr); err != nil {
 				rc.Close()
 				return nil, fmt.Errorf("decode history: %w", err)
 			}
 			kb.History = arr
 		case "bookmarks":
-			var arr []KotatsuBookmark
-			if err := json.NewDecoder(rc).Decode(&arr); err != nil {
+			var arr []json.RawMessage
+			if err := readSection(rc, f.Name, &arr); err != nil {
 				rc.Close()
 				return nil, fmt.Errorf("decode bookmarks: %w", err)
 			}
-			kb.Bookmarks = arr
-		case "index":
+			bookmarks, manga, err := decodeBookmarks(arr)
+			if err != nil {
+				rc.Close()
+				return nil, fmt.Errorf("decode bookmarks: %w", err)
+			}
+			kb.Bookmarks = bookmarks
+			kb.BookmarkManga = manga
+		case "scrobbling":
+			var arr []KotatsuScrobbling
+			if err := readSection(rc, f.Name, &arr); err != nil {
+				rc.Close()
+				return nil, fmt.Errorf("decode scrobbling: %w", err)
+			}
+			kb.Scrobbling = arr
+		case ChaptersEntry:
 			var arr []KotatsuIndexEntry
 			if err := json.NewDecoder(rc).Decode(&arr); err != nil {
+				rc.Close()
+				return nil, fmt.Errorf("decode %s: %w", ChaptersEntry, err)
+			}
+			kb.ChapterIndex = append(kb.ChapterIndex, arr...)
+		case SourceSettingsEntry:
+			dec := json.NewDecoder(rc)
+			dec.UseNumber()
+			var settings map[string]map[string]interface{}
+			if err := dec.Decode(&settings); err != nil {
+				rc.Close()
+				return nil, fmt.Errorf("decode %s: %w", SourceSettingsEntry, err)
+			}
+			kb.SourceSettings = settings
+		case "index":
+			var raw json.RawMessage
+			if err := json.NewDecoder(rc).Decode(&raw); err != nil {
+				rc.Close()
+				return nil, fmt.Errorf("decode index: %w", err)
+			}
+			if err := decodeIndex(raw, kb); err != nil {
 				rc.Close()
 				return nil, fmt.Errorf("decode index: %w", err)
 			}
-			kb.Index = arr
 		case "settings", "reader_grid", "sources":
 			// Read raw bytes for passthrough
 			buf, err := io.ReadAll(rc)
@@ -155,14 +328,211 @@ func LoadKotatsuZip(path string) (*KotatsuBackup, error) {
 				kb.RawReaderGrid = buf
 			case "sources":
 				kb.RawSources = buf
+				// A section in another format is still passed through; Sources
+				// stays nil and converters report it as unreadable
+				var sources []KotatsuSource
+				if err := json.Unmarshal(buf, &sources); err == nil {
+					kb.Sources = sources
+				}
+			}
+		default:
+			if f.FileInfo().IsDir() {
+				break
+			}
+			buf, err := io.ReadAll(rc)
+			if err != nil {
+				rc.Close()
+				return nil, fmt.Errorf("read %s: %w", f.Name, err)
 			}
+			kb.RawEntries = append(kb.RawEntries, KotatsuRawEntry{Name: f.Name, Data: buf})
 		}
 		rc.Close()
 	}
+
+	// Bookmark groups depend on the other sections, so sections are encoded
+	// once everything is loaded
+	kb.loaded = make(map[string]loadedSection, len(raw))
+	for name, data := range raw {
+		kb.loaded[name] = loadedSection{raw: data}
+	}
+	for _, sec := range kb.sections() {
+		if l, ok := kb.loaded[sec.name]; ok {
+			l.encoded, _ = encodeSection(sec.v)
+			kb.loaded[sec.name] = l
+		}
+	}
 	return kb, nil
 }
 
-// WriteKotatsuZip writes a minimal Kotatsu zip containing favourites and categories JSON arrays.
+// section is a Kotatsu section along with the value written to it.
+type section struct {
+	name string
+	v    interface{}
+}
+
+// sections returns the Kotatsu sections WriteKotatsuZip writes, in order.
+// Optional sections are left out when empty, unless they were loaded.
+func (kb *KotatsuBackup) sections() []section {
+	// Kotatsu expects JSON arrays, never null
+	favourites, categories := kb.Favourites, kb.Categories
+	if favourites == nil {
+		favourites = []KotatsuFavouriteEntry{}
+	}
+	if categories == nil {
+		categories = []KotatsuCategory{}
+	}
+	out := []section{{"favourites", favourites}, {"categories", categories}}
+	optional := func(name string, n int, v func() interface{}) {
+		if _, loaded := kb.loaded[name]; n > 0 || loaded {
+			out = append(out, section{name, v()})
+		}
+	}
+	optional("history", len(kb.History), func() interface{} { return kb.History })
+	optional("bookmarks", len(kb.Bookmarks), func() interface{} { return groupBookmarks(kb) })
+	optional("scrobbling", len(kb.Scrobbling), func() interface{} { return kb.Scrobbling })
+	return out
+}
+
+// encodeSection encodes a section value the way WriteKotatsuZip writes it.
+func encodeSection(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeIndex splits the index entry into backup metadata and, if present,
+// per-manga chapter lists. Kotatsu writes an array holding one metadata object;
+// a bare object is accepted too.
+func decodeIndex(raw json.RawMessage, kb *KotatsuBackup) error {
+	var arr []json.RawMessage
+	if err := json.Unmarshal(raw, &arr); err != nil {
+		arr = []json.RawMessage{raw}
+	}
+	for _, el := range arr {
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(el, &probe); err != nil {
+			return err
+		}
+		if _, ok := probe["chapters"]; ok {
+			var e KotatsuIndexEntry
+			if err := json.Unmarshal(el, &e); err != nil {
+				return err
+			}
+			kb.ChapterIndex = append(kb.ChapterIndex, e)
+			continue
+		}
+		if kb.Index == nil {
+			idx := &KotatsuIndex{}
+			if err := json.Unmarshal(el, idx); err != nil {
+				return err
+			}
+			kb.Index = idx
+		}
+	}
+	return nil
+}
+
+// encodeIndex builds the index entry holding the backup metadata.
+func encodeIndex(kb *KotatsuBackup) []KotatsuIndex {
+	idx := KotatsuIndex{AppId: KotatsuAppID, AppVersion: KotatsuAppVersion}
+	if kb.Index != nil {
+		idx = *kb.Index
+		if idx.AppId == "" {
+			idx.AppId = KotatsuAppID
+		}
+	}
+	if idx.CreatedAt == 0 {
+		idx.CreatedAt = time.Now().UnixMilli()
+	}
+	return []KotatsuIndex{idx}
+}
+
+// decodeBookmarks flattens the bookmarks section and returns the manga of its
+// groups, with the group tags. Kotatsu writes one group per manga, but flat
+// bookmark objects are accepted as well.
+func decodeBookmarks(arr []json.RawMessage) ([]KotatsuBookmark, map[int64]KotatsuManga, error) {
+	var out []KotatsuBookmark
+	manga := make(map[int64]KotatsuManga)
+	for _, raw := range arr {
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			return nil, nil, err
+		}
+		if _, grouped := probe["bookmarks"]; !grouped {
+			var bm KotatsuBookmark
+			if err := json.Unmarshal(raw, &bm); err != nil {
+				return nil, nil, err
+			}
+			out = append(out, bm)
+			continue
+		}
+		var g KotatsuBookmarkGroup
+		if err := json.Unmarshal(raw, &g); err != nil {
+			return nil, nil, err
+		}
+		if g.Manga.Id != 0 {
+			if len(g.Manga.Tags) == 0 {
+				g.Manga.Tags = g.Tags
+			}
+			manga[g.Manga.Id] = g.Manga
+		}
+		for _, bm := range g.Bookmarks {
+			if bm.MangaId == 0 {
+				bm.MangaId = g.Manga.Id
+			}
+			out = append(out, bm)
+		}
+	}
+	return out, manga, nil
+}
+
+// groupBookmarks builds the on-disk bookmarks section. The manga of each group is
+// taken from the favourites or history entries with the same id, or else from
+// the bookmark group it was loaded from; bookmarks of manga found in none of
+// them are dropped since Kotatsu could not restore them.
+func groupBookmarks(kb *KotatsuBackup) []KotatsuBookmarkGroup {
+	manga := make(map[int64]KotatsuManga)
+	for id, m := range kb.BookmarkManga {
+		manga[id] = m
+	}
+	for _, h := range kb.History {
+		manga[h.MangaId] = h.Manga
+	}
+	for _, fav := range kb.Favourites {
+		manga[fav.MangaId] = fav.Manga
+	}
+
+	var groups []KotatsuBookmarkGroup
+	pos := make(map[int64]int)
+	for _, bm := range kb.Bookmarks {
+		m, ok := manga[bm.MangaId]
+		if !ok {
+			continue
+		}
+		i, ok := pos[bm.MangaId]
+		if !ok {
+			tags := m.Tags
+			if tags == nil {
+				tags = []KotatsuTag{}
+			}
+			i = len(groups)
+			pos[bm.MangaId] = i
+			groups = append(groups, KotatsuBookmarkGroup{Manga: m, Tags: tags})
+		}
+		groups[i].Bookmarks = append(groups[i].Bookmarks, bm)
+	}
+	return groups
+}
+
+// WriteKotatsuZip writes a Kotatsu zip containing the index metadata and the
+// favourites, categories, history and bookmarks JSON arrays. Raw sections and unknown entries captured by LoadKotatsuZip
+// are written back byte for byte, so a loaded backup can be written out again;
+// so are loaded sections whose typed value was not changed.
 func WriteKotatsuZip(path string, kb *KotatsuBackup) error {
 	f, err := os.Create(path)
 	if err != nil {
@@ -172,22 +542,67 @@ func WriteKotatsuZip(path string, kb *KotatsuBackup) error {
 	zw := zip.NewWriter(f)
 	defer zw.Close()
 
-	add := func(name string, v interface{}) error {
+	addRaw := func(name string, data []byte) error {
 		w, err := zw.Create(name)
 		if err != nil {
 			return err
 		}
-		enc := json.NewEncoder(w)
-		enc.SetEscapeHTML(false)
-		enc.SetIndent("", "")
-		return enc.Encode(v)
+		_, err = w.Write(data)
+		return err
+	}
+	add := func(name string, v interface{}) error {
+		data, err := encodeSection(v)
+		if err != nil {
+			return err
+		}
+		if l, ok := kb.loaded[name]; ok && bytes.Equal(l.encoded, data) {
+			data = l.raw
+		}
+		return addRaw(name, data)
 	}
 
-	if err := add("favourites", kb.Favourites); err != nil {
-		return fmt.Errorf("write favourites: %w", err)
+	if err := add("index", encodeIndex(kb)); err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+	for _, sec := range kb.sections() {
+		if err := add(sec.name, sec.v); err != nil {
+			return fmt.Errorf("write %s: %w", sec.name, err)
+		}
+	}
+	if len(kb.ChapterIndex) > 0 {
+		if err := add(ChaptersEntry, kb.ChapterIndex); err != nil {
+			return fmt.Errorf("write %s: %w", ChaptersEntry, err)
+		}
 	}
-	if err := add("categories", kb.Categories); err != nil {
-		return fmt.Errorf("write categories: %w", err)
+	if len(kb.SourceSettings) > 0 {
+		if err := add(SourceSettingsEntry, kb.SourceSettings); err != nil {
+			return fmt.Errorf("write %s: %w", SourceSettingsEntry, err)
+		}
+	}
+	if len(kb.RawSources) == 0 && len(kb.Sources) > 0 {
+		if err := add("sources", kb.Sources); err != nil {
+			return fmt.Errorf("write sources: %w", err)
+		}
+	}
+	for _, raw := range []struct {
+		name string
+		data []byte
+	}{
+		{"settings", kb.RawSettings},
+		{"reader_grid", kb.RawReaderGrid},
+		{"sources", kb.RawSources},
+	} {
+		if len(raw.data) == 0 {
+			continue
+		}
+		if err := addRaw(raw.name, raw.data); err != nil {
+			return fmt.Errorf("write %s: %w", raw.name, err)
+		}
+	}
+	for _, e := range kb.RawEntries {
+		if err := addRaw(e.Name, e.Data); err != nil {
+			return fmt.Errorf("write %s: %w", e.Name, err)
+		}
 	}
 	return nil
 }
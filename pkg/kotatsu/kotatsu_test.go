@@ -0,0 +1,96 @@
+package kotatsu
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestZip(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, data := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestZip(t *testing.T, path string) map[string]string {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	out := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[f.Name] = string(data)
+	}
+	return out
+}
+
+// Fields the structs do not model, such as deleted_at and alt_titles, must
+// survive a load and write of sections the caller did not change.
+func TestWriteKotatsuZipKeepsUnchangedSections(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.zip"), filepath.Join(dir, "out.zip")
+	entries := map[string]string{
+		"favourites": `[{"manga_id":1,"category_id":2,"sort_key":0,"pinned":false,"created_at":5,"deleted_at":0,` +
+			`"manga":{"id":1,"title":"T","alt_titles":["A","B"],"url":"/t","source":"MANGADEX","tags":[]}}]`,
+		"categories": `[{"category_id":2,"created_at":1,"sort_key":0,"title":"Reading","order":"NEWEST","track":true,"show_in_lib":true,"deleted_at":0}]`,
+		"history":    `[{"manga_id":1,"created_at":1,"updated_at":2,"chapter_id":3,"page":4,"scroll":0,"percent":0.5,"chapters":10,"deleted_at":0,"manga":{"id":1,"title":"T","source":"MANGADEX"}}]`,
+	}
+	writeTestZip(t, in, entries)
+
+	kb, err := LoadKotatsuZip(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKotatsuZip(out, kb); err != nil {
+		t.Fatal(err)
+	}
+	got := readTestZip(t, out)
+	for name, want := range entries {
+		if got[name] != want {
+			t.Errorf("%s = %s, want it unchanged", name, got[name])
+		}
+	}
+
+	// Changed sections are encoded from the structs
+	kb.Favourites[0].Pinned = true
+	if err := WriteKotatsuZip(out, kb); err != nil {
+		t.Fatal(err)
+	}
+	got = readTestZip(t, out)
+	if !strings.Contains(got["favourites"], `"pinned":true`) {
+		t.Errorf("favourites = %s, want the change written", got["favourites"])
+	}
+	if got["history"] != entries["history"] {
+		t.Errorf("history = %s, want it unchanged", got["history"])
+	}
+}
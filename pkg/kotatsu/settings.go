@@ -0,0 +1,38 @@
+package kotatsu
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// DecodeSettings parses the settings section: Kotatsu writes an array holding a
+// single object of preference key to value; a bare object is accepted too.
+// Numbers are kept as json.Number so integer settings are written back as
+// integers.
+func DecodeSettings(raw json.RawMessage) (map[string]interface{}, error) {
+	settings := make(map[string]interface{})
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return settings, nil
+	}
+	var arr []json.RawMessage
+	if err := json.Unmarshal(raw, &arr); err != nil {
+		arr = []json.RawMessage{raw}
+	}
+	for _, el := range arr {
+		dec := json.NewDecoder(bytes.NewReader(el))
+		dec.UseNumber()
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); err != nil {
+			return nil, err
+		}
+		for k, v := range obj {
+			settings[k] = v
+		}
+	}
+	return settings, nil
+}
+
+// EncodeSettings builds the settings section from preference key to value.
+func EncodeSettings(settings map[string]interface{}) (json.RawMessage, error) {
+	return json.Marshal([]map[string]interface{}{settings})
+}
@@ -0,0 +1,140 @@
+package mihon
+
+import (
+	"fmt"
+	"strings"
+
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+	"google.golang.org/protobuf/proto"
+)
+
+// Type names Mihon writes into PreferenceValue.type. They are the serial names
+// of its PreferenceValue subclasses, i.e. the fully qualified Kotlin class names.
+const (
+	preferenceTypePrefix = "eu.kanade.tachiyomi.data.backup.models."
+
+	IntPreferenceType       = preferenceTypePrefix + "IntPreferenceValue"
+	LongPreferenceType      = preferenceTypePrefix + "LongPreferenceValue"
+	FloatPreferenceType     = preferenceTypePrefix + "FloatPreferenceValue"
+	StringPreferenceType    = preferenceTypePrefix + "StringPreferenceValue"
+	BooleanPreferenceType   = preferenceTypePrefix + "BooleanPreferenceValue"
+	StringSetPreferenceType = preferenceTypePrefix + "StringSetPreferenceValue"
+)
+
+// DecodePreference returns the Go value held by a preference value: an int32,
+// int64, float32, string, bool or []string. Type names are matched on the class
+// name alone, so backups from forks with other package names decode too.
+func DecodePreference(v *pb.PreferenceValue) (interface{}, error) {
+	typ := v.GetType()
+	data := v.GetTruevalue()
+	switch typ[strings.LastIndex(typ, ".")+1:] {
+	case "IntPreferenceValue":
+		var m pb.IntPreferenceValue
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", typ, err)
+		}
+		return m.GetValue(), nil
+	case "LongPreferenceValue":
+		var m pb.LongPreferenceValue
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", typ, err)
+		}
+		return m.GetValue(), nil
+	case "FloatPreferenceValue":
+		var m pb.FloatPreferenceValue
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", typ, err)
+		}
+		return m.GetValue(), nil
+	case "StringPreferenceValue":
+		var m pb.StringPreferenceValue
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", typ, err)
+		}
+		return m.GetValue(), nil
+	case "BooleanPreferenceValue":
+		var m pb.BooleanPreferenceValue
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", typ, err)
+		}
+		return m.GetValue(), nil
+	case "StringSetPreferenceValue":
+		var m pb.StringSetPreferenceValue
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", typ, err)
+		}
+		return m.GetValue(), nil
+	}
+	return nil, fmt.Errorf("unknown preference type %q", typ)
+}
+
+// EncodePreference builds a preference value from an int32, int64, float32,
+// string, bool or []string. Go's int and float64 are accepted as Int and Float.
+func EncodePreference(value interface{}) (*pb.PreferenceValue, error) {
+	var typ string
+	var m proto.Message
+	switch v := value.(type) {
+	case int32:
+		typ, m = IntPreferenceType, &pb.IntPreferenceValue{Value: proto.Int32(v)}
+	case int:
+		typ, m = IntPreferenceType, &pb.IntPreferenceValue{Value: proto.Int32(int32(v))}
+	case int64:
+		typ, m = LongPreferenceType, &pb.LongPreferenceValue{Value: proto.Int64(v)}
+	case float32:
+		typ, m = FloatPreferenceType, &pb.FloatPreferenceValue{Value: proto.Float32(v)}
+	case float64:
+		typ, m = FloatPreferenceType, &pb.FloatPreferenceValue{Value: proto.Float32(float32(v))}
+	case string:
+		typ, m = StringPreferenceType, &pb.StringPreferenceValue{Value: proto.String(v)}
+	case bool:
+		typ, m = BooleanPreferenceType, &pb.BooleanPreferenceValue{Value: proto.Bool(v)}
+	case []string:
+		typ, m = StringSetPreferenceType, &pb.StringSetPreferenceValue{Value: v}
+	default:
+		return nil, fmt.Errorf("unsupported preference value type %T", value)
+	}
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("encode %s: %w", typ, err)
+	}
+	return &pb.PreferenceValue{Type: proto.String(typ), Truevalue: data}, nil
+}
+
+// GetPreference looks up key in prefs and decodes its value. ok is false if
+// the key is not present.
+func GetPreference(prefs []*pb.BackupPreference, key string) (value interface{}, ok bool, err error) {
+	for _, p := range prefs {
+		if p.GetKey() == key {
+			value, err = DecodePreference(p.GetValue())
+			return value, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// SetPreference encodes value and stores it under key, replacing the current
+// value or appending a new preference.
+func SetPreference(prefs []*pb.BackupPreference, key string, value interface{}) ([]*pb.BackupPreference, error) {
+	v, err := EncodePreference(value)
+	if err != nil {
+		return prefs, fmt.Errorf("preference %s: %w", key, err)
+	}
+	for _, p := range prefs {
+		if p.GetKey() == key {
+			p.Value = v
+			return prefs, nil
+		}
+	}
+	return append(prefs, &pb.BackupPreference{Key: proto.String(key), Value: v}), nil
+}
+
+// DeletePreference removes key from prefs.
+func DeletePreference(prefs []*pb.BackupPreference, key string) []*pb.BackupPreference {
+	out := prefs[:0]
+	for _, p := range prefs {
+		if p.GetKey() != key {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package mihon
+
+import (
+	"reflect"
+	"testing"
+
+	pb "github.com/galpt/mk-bkconv/proto/mihon"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestPreferenceRoundTrip(t *testing.T) {
+	tests := []struct {
+		in       interface{}
+		wantType string
+		want     interface{}
+	}{
+		{int32(-7), IntPreferenceType, int32(-7)},
+		{3, IntPreferenceType, int32(3)},
+		{int64(1) << 40, LongPreferenceType, int64(1) << 40},
+		{float32(1.5), FloatPreferenceType, float32(1.5)},
+		{0.25, FloatPreferenceType, float32(0.25)},
+		{"dark", StringPreferenceType, "dark"},
+		{"", StringPreferenceType, ""},
+		{true, BooleanPreferenceType, true},
+		{false, BooleanPreferenceType, false},
+		{[]string{"1", "2"}, StringSetPreferenceType, []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		v, err := EncodePreference(tt.in)
+		if err != nil {
+			t.Errorf("EncodePreference(%#v): %v", tt.in, err)
+			continue
+		}
+		if v.GetType() != tt.wantType {
+			t.Errorf("EncodePreference(%#v) type = %s, want %s", tt.in, v.GetType(), tt.wantType)
+		}
+		got, err := DecodePreference(v)
+		if err != nil {
+			t.Errorf("DecodePreference(%#v): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("round trip of %#v = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncodePreferenceUnsupported(t *testing.T) {
+	if _, err := EncodePreference([]int{1}); err == nil {
+		t.Error("EncodePreference([]int): no error")
+	}
+}
+
+// Forks write the same classes under their own package names.
+func TestDecodePreferenceForkTypeName(t *testing.T) {
+	data, err := proto.Marshal(&pb.BooleanPreferenceValue{Value: proto.Bool(true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{
+		BooleanPreferenceType,
+		"org.example.fork.data.backup.models.BooleanPreferenceValue",
+		"BooleanPreferenceValue",
+	} {
+		got, err := DecodePreference(&pb.PreferenceValue{Type: proto.String(typ), Truevalue: data})
+		if err != nil || got != true {
+			t.Errorf("DecodePreference(%s) = %v, %v; want true", typ, got, err)
+		}
+	}
+
+	if _, err := DecodePreference(&pb.PreferenceValue{Type: proto.String("eu.kanade.tachiyomi.data.backup.models.DoublePreferenceValue"), Truevalue: data}); err == nil {
+		t.Error("unknown type: no error")
+	}
+}
+
+func TestSetGetDeletePreference(t *testing.T) {
+	prefs, err := SetPreference(nil, "a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs, err = SetPreference(prefs, "b", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if prefs, err = SetPreference(prefs, "a", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(prefs) != 2 {
+		t.Fatalf("got %d preferences, want 2", len(prefs))
+	}
+	if v, ok, err := GetPreference(prefs, "a"); err != nil || !ok || v != false {
+		t.Errorf("GetPreference(a) = %v, %v, %v; want the replaced value false", v, ok, err)
+	}
+	if _, err := SetPreference(prefs, "c", struct{}{}); err == nil {
+		t.Error("SetPreference with an unsupported value: no error")
+	}
+
+	prefs = DeletePreference(prefs, "a")
+	if _, ok, _ := GetPreference(prefs, "a"); ok {
+		t.Error("a still present after DeletePreference")
+	}
+	if v, ok, _ := GetPreference(prefs, "b"); !ok || v != "x" {
+		t.Errorf("GetPreference(b) = %v, %v after deleting a", v, ok)
+	}
+}
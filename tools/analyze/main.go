@@ -13,6 +13,7 @@ import (
 
 func main() {
 	in := flag.String("in", "", "input mihon backup file (.tachibk)")
+	prefs := flag.Bool("prefs", false, "also list app and source preferences with their values")
 	flag.Parse()
 	if *in == "" {
 		log.Fatal("-in required")
@@ -39,12 +40,32 @@ func main() {
 		analyzeBackupManga(m)
 	}
 
+	if *prefs {
+		fmt.Printf("\n=== PREFERENCES ===\n")
+		printPreferences("", backup.BackupPreferences)
+		for _, sp := range backup.BackupSourcePreferences {
+			fmt.Printf("\n[%s]\n", sp.GetSourceKey())
+			printPreferences("  ", sp.GetPrefs())
+		}
+	}
+
 	if len(backup.BackupManga) > 0 {
 		fmt.Printf("\n=== CHECKING FOR COMMON ISSUES ===\n")
 		checkForIssues(backup)
 	}
 }
 
+func printPreferences(indent string, prefs []*pb.BackupPreference) {
+	for _, p := range prefs {
+		v, err := mihon.DecodePreference(p.GetValue())
+		if err != nil {
+			fmt.Printf("%s%s: <%v>\n", indent, p.GetKey(), err)
+			continue
+		}
+		fmt.Printf("%s%s = %v\n", indent, p.GetKey(), v)
+	}
+}
+
 func analyzeBackupManga(m *pb.BackupManga) {
 	data, _ := json.MarshalIndent(map[string]interface{}{
 		"source":             m.GetSource(),
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/galpt/mk-bkconv/pkg/convert"
+)
+
+// update-index refreshes the extension index snapshot embedded in pkg/convert.
+// Run it from the repository root, then rebuild.
+func main() {
+	in := flag.String("in", "", "read the index from this file instead of downloading it")
+	url := flag.String("url", convert.KeiyoushiIndexURL, "index URL")
+	dir := flag.String("dir", filepath.Join("pkg", "convert", "data"), "snapshot directory")
+	flag.Parse()
+
+	var data []byte
+	var err error
+	if *in != "" {
+		data, err = os.ReadFile(*in)
+	} else {
+		data, err = download(*url)
+	}
+	if err != nil {
+		log.Fatalf("failed to read index: %v", err)
+	}
+
+	exts, err := convert.ParseExtensionIndex(bytes.NewReader(data))
+	if err != nil {
+		log.Fatalf("not a valid extension index: %v", err)
+	}
+	sources := 0
+	for _, ext := range exts {
+		sources += len(ext.Sources)
+	}
+
+	date := time.Now().UTC().Format("2006-01-02")
+	if err := os.WriteFile(filepath.Join(*dir, "keiyoushi_index.min.json"), data, 0o644); err != nil {
+		log.Fatalf("failed to write snapshot: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(*dir, "keiyoushi_index.date"), []byte(date+"\n"), 0o644); err != nil {
+		log.Fatalf("failed to write snapshot date: %v", err)
+	}
+	fmt.Printf("Wrote snapshot of %d extensions (%d sources) dated %s\n", len(exts), sources, date)
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}